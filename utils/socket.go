@@ -83,3 +83,14 @@ func (s *Socket) SendMessage(parts ...interface{}) (total int, err error) {
 }
 
 /* }}} */
+
+/* {{{ func (s *Socket) SetIdentity(identity string) error
+ *
+ */
+func (s *Socket) SetIdentity(identity string) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.soc.SetIdentity(identity)
+}
+
+/* }}} */
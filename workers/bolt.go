@@ -0,0 +1,977 @@
+package workers
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/boltdb/bolt"
+)
+
+/*
+ * 内嵌存储(boltdb, 纯go), 不需要redis, 适合小规模部署以及CI
+ * 所有key登记在kv桶(类型, 过期时间, 版本号, 值), hash/set/zset的内容在各自桶下以key命名的子桶
+ * zset子桶下: m为member=>时间戳, s为时间戳+member(按时间排序)
+ */
+
+const (
+	_STORAGE_BOLT = "bolt"
+
+	_BOLT_STRING byte = 1
+	_BOLT_HASH   byte = 2
+	_BOLT_SET    byte = 3
+	_BOLT_ZSET   byte = 4
+
+	_BOLT_PURGE_INTERVAL = time.Minute //清理过期key的间隔
+)
+
+var (
+	bucketKV    = []byte("kv")
+	bucketHash  = []byte("hash")
+	bucketSet   = []byte("set")
+	bucketZSet  = []byte("zset")
+	bucketLock  = []byte("lock")
+	bucketFence = []byte("fence")
+
+	zsetMembers = []byte("m")
+	zsetScores  = []byte("s")
+	boltTrue    = []byte{1} //set成员的值
+
+	boltStore     *boltStorage //同一个文件只能打开一次
+	boltStoreLock sync.Mutex
+)
+
+type boltStorage struct {
+	db *bolt.DB
+}
+
+type boltRecord struct {
+	typ      byte
+	expireAt int64
+	ver      int64
+	value    []byte
+}
+
+func init() {
+	RegisterStorage(_STORAGE_BOLT, openBolt)
+}
+
+/* {{{ func openBolt(opt *StorageOption) (Storage, error)
+ * 数据文件为配置bolt_path, 选项无效
+ */
+func openBolt(opt *StorageOption) (Storage, error) {
+	boltStoreLock.Lock()
+	defer boltStoreLock.Unlock()
+	if boltStore != nil {
+		return boltStore, nil
+	}
+	db, err := bolt.Open(boltPath, 0600, &bolt.Options{Timeout: 3 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, name := range [][]byte{bucketKV, bucketHash, bucketSet, bucketZSet, bucketLock, bucketFence} {
+			if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	boltStore = &boltStorage{db: db}
+	go boltStore.purge()
+	return boltStore, nil
+}
+
+/* }}} */
+
+/* {{{ func decodeRecord(b []byte) *boltRecord
+ * 格式: 类型(1) 过期时间(8) 版本号(8) 值
+ */
+func decodeRecord(b []byte) *boltRecord {
+	if len(b) < 17 {
+		return nil
+	}
+	r := &boltRecord{
+		typ:      b[0],
+		expireAt: int64(binary.BigEndian.Uint64(b[1:9])),
+		ver:      int64(binary.BigEndian.Uint64(b[9:17])),
+	}
+	r.value = append([]byte(nil), b[17:]...) //bolt返回的内容只在事务内有效
+	return r
+}
+
+/* }}} */
+
+/* {{{ func (r *boltRecord) encode() []byte
+ *
+ */
+func (r *boltRecord) encode() []byte {
+	b := make([]byte, 17+len(r.value))
+	b[0] = r.typ
+	binary.BigEndian.PutUint64(b[1:9], uint64(r.expireAt))
+	binary.BigEndian.PutUint64(b[9:17], uint64(r.ver))
+	copy(b[17:], r.value)
+	return b
+}
+
+/* }}} */
+
+/* {{{ func (r *boltRecord) expired(now int64) bool
+ *
+ */
+func (r *boltRecord) expired(now int64) bool {
+	return r.expireAt > 0 && r.expireAt <= now
+}
+
+/* }}} */
+
+/* {{{ func boltScore(ts int64) []byte
+ * 时间戳编码, 字节序与数值大小一致
+ */
+func boltScore(ts int64) []byte {
+	b := make([]byte, 8)
+	binary.BigEndian.PutUint64(b, uint64(ts)^(1<<63))
+	return b
+}
+
+/* }}} */
+
+/* {{{ func boltTs(b []byte) int64
+ *
+ */
+func boltTs(b []byte) int64 {
+	return int64(binary.BigEndian.Uint64(b[:8]) ^ (1 << 63))
+}
+
+/* }}} */
+
+/* {{{ func boltContainer(typ byte) []byte
+ * 类型对应的桶
+ */
+func boltContainer(typ byte) []byte {
+	switch typ {
+	case _BOLT_HASH:
+		return bucketHash
+	case _BOLT_SET:
+		return bucketSet
+	case _BOLT_ZSET:
+		return bucketZSet
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func boltGet(tx *bolt.Tx, key string) *boltRecord
+ * 未过期的记录, 不存在返回nil
+ */
+func boltGet(tx *bolt.Tx, key string) *boltRecord {
+	r := decodeRecord(tx.Bucket(bucketKV).Get([]byte(key)))
+	if r == nil || r.expired(time.Now().Unix()) {
+		return nil
+	}
+	return r
+}
+
+/* }}} */
+
+/* {{{ func boltDel(tx *bolt.Tx, key string) error
+ * 删除key以及hash/set/zset的内容
+ */
+func boltDel(tx *bolt.Tx, key string) error {
+	k := []byte(key)
+	if err := tx.Bucket(bucketKV).Delete(k); err != nil {
+		return err
+	}
+	for _, name := range [][]byte{bucketHash, bucketSet, bucketZSet} {
+		if b := tx.Bucket(name); b.Bucket(k) != nil {
+			if err := b.DeleteBucket(k); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func boltBucket(tx *bolt.Tx, key string, typ byte, create bool) (*bolt.Bucket, error)
+ * hash/set/zset的子桶, 不存在且不创建返回nil, 类型不对返回ErrWrongType
+ */
+func boltBucket(tx *bolt.Tx, key string, typ byte, create bool) (*bolt.Bucket, error) {
+	r := boltGet(tx, key)
+	if r != nil && r.typ != typ {
+		return nil, ErrWrongType
+	}
+	k := []byte(key)
+	if r != nil {
+		if b := tx.Bucket(boltContainer(typ)).Bucket(k); b != nil {
+			return b, nil
+		}
+	} else if !create {
+		return nil, nil
+	} else {
+		if err := boltDel(tx, key); err != nil { //清理过期的内容
+			return nil, err
+		}
+		r = &boltRecord{typ: typ}
+		if err := tx.Bucket(bucketKV).Put(k, r.encode()); err != nil {
+			return nil, err
+		}
+	}
+	b, err := tx.Bucket(boltContainer(typ)).CreateBucketIfNotExists(k)
+	if err != nil || typ != _BOLT_ZSET {
+		return b, err
+	}
+	if _, err = b.CreateBucketIfNotExists(zsetMembers); err == nil {
+		_, err = b.CreateBucketIfNotExists(zsetScores)
+	}
+	return b, err
+}
+
+/* }}} */
+
+/* {{{ func boltCleanup(tx *bolt.Tx, key string, b *bolt.Bucket) error
+ * 与redis一样, 内容为空的hash/set/zset删除key
+ */
+func boltCleanup(tx *bolt.Tx, key string, b *bolt.Bucket) error {
+	if k, _ := b.Cursor().First(); k != nil {
+		return nil
+	}
+	return boltDel(tx, key)
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) purge()
+ * 定期删除过期的key
+ */
+func (bs *boltStorage) purge() {
+	for now := range time.Tick(_BOLT_PURGE_INTERVAL) {
+		bs.db.Update(func(tx *bolt.Tx) error {
+			var keys []string
+			tx.Bucket(bucketKV).ForEach(func(k, v []byte) error {
+				if r := decodeRecord(v); r != nil && r.expired(now.Unix()) {
+					keys = append(keys, string(k))
+				}
+				return nil
+			})
+			for _, k := range keys {
+				if err := boltDel(tx, k); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) Get(key string) (v string, err error)
+ *
+ */
+func (bs *boltStorage) Get(key string) (v string, err error) {
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		r := boltGet(tx, key)
+		if r == nil {
+			return ErrNil
+		} else if r.typ != _BOLT_STRING {
+			return ErrWrongType
+		}
+		v = string(r.value)
+		return nil
+	})
+	return
+}
+
+/* }}} */
+
+/* {{{ func boltSet(tx *bolt.Tx, key, value string, expire int) (int64, error)
+ * 覆盖任何类型, 版本号加1, 返回新版本号
+ */
+func boltSet(tx *bolt.Tx, key, value string, expire int) (int64, error) {
+	ver := int64(1)
+	if r := boltGet(tx, key); r != nil && r.typ == _BOLT_STRING {
+		ver = r.ver + 1
+	} else if err := boltDel(tx, key); err != nil {
+		return 0, err
+	}
+	r := &boltRecord{typ: _BOLT_STRING, ver: ver, value: []byte(value)}
+	if expire > 0 {
+		r.expireAt = time.Now().Unix() + int64(expire)
+	}
+	return ver, tx.Bucket(bucketKV).Put([]byte(key), r.encode())
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) Set(key, value string, expire int) error
+ *
+ */
+func (bs *boltStorage) Set(key, value string, expire int) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		_, err := boltSet(tx, key, value, expire)
+		return err
+	})
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) Del(key string) error
+ *
+ */
+func (bs *boltStorage) Del(key string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		return boltDel(tx, key)
+	})
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) Schedule(key, member string, ts int) error
+ *
+ */
+func (bs *boltStorage) Schedule(key, member string, ts int) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		b, err := boltBucket(tx, key, _BOLT_ZSET, true)
+		if err != nil {
+			return err
+		}
+		return boltZAdd(b, member, int64(ts))
+	})
+}
+
+/* }}} */
+
+/* {{{ func boltZAdd(b *bolt.Bucket, member string, ts int64) error
+ *
+ */
+func boltZAdd(b *bolt.Bucket, member string, ts int64) error {
+	m := []byte(member)
+	ms, ss := b.Bucket(zsetMembers), b.Bucket(zsetScores)
+	if old := ms.Get(m); old != nil {
+		if err := ss.Delete(append(append([]byte(nil), old...), m...)); err != nil {
+			return err
+		}
+	}
+	score := boltScore(ts)
+	if err := ms.Put(m, score); err != nil {
+		return err
+	}
+	return ss.Put(append(score, m...), boltTrue)
+}
+
+/* }}} */
+
+/* {{{ func boltZRem(b *bolt.Bucket, member string) (bool, error)
+ *
+ */
+func boltZRem(b *bolt.Bucket, member string) (bool, error) {
+	m := []byte(member)
+	ms := b.Bucket(zsetMembers)
+	old := ms.Get(m)
+	if old == nil {
+		return false, nil
+	}
+	if err := b.Bucket(zsetScores).Delete(append(append([]byte(nil), old...), m...)); err != nil {
+		return false, err
+	}
+	return true, ms.Delete(m)
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) Claim(key string, now int64, batch int) (members, scores []string, err error)
+ * 写事务是串行的, 每项只被领取一次
+ */
+func (bs *boltStorage) Claim(key string, now int64, batch int) (members, scores []string, err error) {
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		b, err := boltBucket(tx, key, _BOLT_ZSET, false)
+		if err != nil || b == nil {
+			return err
+		}
+		c := b.Bucket(zsetScores).Cursor()
+		for k, _ := c.First(); k != nil && len(members) < batch; k, _ = c.Next() {
+			ts := boltTs(k)
+			if ts > now {
+				break
+			}
+			members = append(members, string(k[8:]))
+			scores = append(scores, strconv.FormatInt(ts, 10))
+		}
+		for _, m := range members {
+			if _, err := boltZRem(b, m); err != nil {
+				return err
+			}
+		}
+		return boltCleanup(tx, key, b.Bucket(zsetMembers))
+	})
+	return
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) Unschedule(key string, members ...string) error
+ *
+ */
+func (bs *boltStorage) Unschedule(key string, members ...string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		b, err := boltBucket(tx, key, _BOLT_ZSET, false)
+		if err != nil || b == nil {
+			return err
+		}
+		for _, m := range members {
+			if _, err := boltZRem(b, m); err != nil {
+				return err
+			}
+		}
+		return boltCleanup(tx, key, b.Bucket(zsetMembers))
+	})
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) Reschedule(key, member string, ts int) error
+ *
+ */
+func (bs *boltStorage) Reschedule(key, member string, ts int) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		b, err := boltBucket(tx, key, _BOLT_ZSET, false)
+		if err != nil {
+			return err
+		} else if b == nil || b.Bucket(zsetMembers).Get([]byte(member)) == nil {
+			return ErrNil
+		}
+		return boltZAdd(b, member, int64(ts))
+	})
+}
+
+/* }}} */
+
+/* {{{ func parseScore(s string, min bool) (int64, error)
+ * 与redis一样的范围: -inf, +inf, (为不包含
+ */
+func parseScore(s string, min bool) (int64, error) {
+	switch strings.ToLower(s) {
+	case "-inf":
+		return math.MinInt64, nil
+	case "+inf", "inf":
+		return math.MaxInt64, nil
+	}
+	exclusive := strings.HasPrefix(s, "(")
+	f, err := strconv.ParseFloat(strings.TrimPrefix(s, "("), 64)
+	if err != nil {
+		return 0, fmt.Errorf("min or max is not a float")
+	}
+	if min {
+		v := int64(math.Ceil(f))
+		if exclusive && float64(v) == f {
+			v++
+		}
+		return v, nil
+	}
+	v := int64(math.Floor(f))
+	if exclusive && float64(v) == f {
+		v--
+	}
+	return v, nil
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) Schedules(key, min, max string, count int) (members, scores []string, err error)
+ *
+ */
+func (bs *boltStorage) Schedules(key, min, max string, count int) (members, scores []string, err error) {
+	var lo, hi int64
+	if lo, err = parseScore(min, true); err != nil {
+		return
+	}
+	if hi, err = parseScore(max, false); err != nil {
+		return
+	}
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		b, err := boltBucket(tx, key, _BOLT_ZSET, false)
+		if err != nil || b == nil {
+			return err
+		}
+		c := b.Bucket(zsetScores).Cursor()
+		for k, _ := c.Seek(boltScore(lo)); k != nil; k, _ = c.Next() {
+			ts := boltTs(k)
+			if ts > hi || (count > 0 && len(members) >= count) {
+				break
+			}
+			members = append(members, string(k[8:]))
+			scores = append(scores, strconv.FormatInt(ts, 10))
+		}
+		return nil
+	})
+	return
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) Exists(key string) (ok bool, err error)
+ *
+ */
+func (bs *boltStorage) Exists(key string) (ok bool, err error) {
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		ok = boltGet(tx, key) != nil
+		return nil
+	})
+	return
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) TTL(key string) (ttl int64, err error)
+ *
+ */
+func (bs *boltStorage) TTL(key string) (ttl int64, err error) {
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		r := boltGet(tx, key)
+		if r == nil {
+			return ErrNil
+		} else if r.expireAt == 0 {
+			ttl = -1
+		} else {
+			ttl = r.expireAt - time.Now().Unix()
+		}
+		return nil
+	})
+	return
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) Expire(key string, expire int) error
+ *
+ */
+func (bs *boltStorage) Expire(key string, expire int) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		r := boltGet(tx, key)
+		if r == nil {
+			return ErrNil
+		}
+		r.expireAt = 0
+		if expire > 0 {
+			r.expireAt = time.Now().Unix() + int64(expire)
+		}
+		return tx.Bucket(bucketKV).Put([]byte(key), r.encode())
+	})
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) Incr(key string, delta int64) (n int64, err error)
+ *
+ */
+func (bs *boltStorage) Incr(key string, delta int64) (n int64, err error) {
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		r := boltGet(tx, key)
+		if r == nil {
+			r = &boltRecord{typ: _BOLT_STRING}
+		} else if r.typ != _BOLT_STRING {
+			return ErrWrongType
+		} else if n, err = strconv.ParseInt(string(r.value), 10, 64); err != nil {
+			return fmt.Errorf("value is not an integer")
+		}
+		n += delta
+		r.ver++
+		r.value = []byte(strconv.FormatInt(n, 10))
+		return tx.Bucket(bucketKV).Put([]byte(key), r.encode())
+	})
+	return
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) MGet(keys ...string) (r []string, err error)
+ *
+ */
+func (bs *boltStorage) MGet(keys ...string) (r []string, err error) {
+	r = make([]string, len(keys))
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		for i, k := range keys {
+			if rec := boltGet(tx, k); rec != nil && rec.typ == _BOLT_STRING {
+				r[i] = string(rec.value)
+			}
+		}
+		return nil
+	})
+	return
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) MSet(pairs ...string) error
+ *
+ */
+func (bs *boltStorage) MSet(pairs ...string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		for i := 0; i+1 < len(pairs); i += 2 {
+			if _, err := boltSet(tx, pairs[i], pairs[i+1], 0); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) GetV(key string) (value string, ver int64, err error)
+ *
+ */
+func (bs *boltStorage) GetV(key string) (value string, ver int64, err error) {
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		r := boltGet(tx, key)
+		if r == nil {
+			return ErrNil
+		} else if r.typ != _BOLT_STRING {
+			return ErrWrongType
+		}
+		value, ver = string(r.value), r.ver
+		return nil
+	})
+	return
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) CAS(key, value, mode, expected string, expire int) (ver int64, err error)
+ * 与redis的_CAS_SCRIPT一致
+ */
+func (bs *boltStorage) CAS(key, value, mode, expected string, expire int) (ver int64, err error) {
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		r := boltGet(tx, key)
+		if r != nil && r.typ != _BOLT_STRING {
+			return ErrWrongType
+		}
+		cur := int64(0)
+		if r != nil {
+			cur = r.ver
+		}
+		switch mode {
+		case CAS_NX:
+			if r != nil {
+				return ErrConflict
+			}
+		case CAS_XX:
+			if r == nil {
+				return ErrConflict
+			}
+		case CAS_VALUE:
+			if (r == nil && expected != "") || (r != nil && string(r.value) != expected) {
+				return ErrConflict
+			}
+		case CAS_VERSION:
+			if strconv.FormatInt(cur, 10) != expected {
+				return ErrConflict
+			}
+		default:
+			return fmt.Errorf("unknown cas mode: %s", mode)
+		}
+		var err error
+		ver, err = boltSet(tx, key, value, expire)
+		return err
+	})
+	return
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) HGet(key, field string) (v string, err error)
+ *
+ */
+func (bs *boltStorage) HGet(key, field string) (v string, err error) {
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		b, err := boltBucket(tx, key, _BOLT_HASH, false)
+		if err != nil {
+			return err
+		} else if b == nil {
+			return ErrNil
+		}
+		fv := b.Get([]byte(field))
+		if fv == nil {
+			return ErrNil
+		}
+		v = string(fv)
+		return nil
+	})
+	return
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) HSet(key, field, value string) error
+ *
+ */
+func (bs *boltStorage) HSet(key, field, value string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		b, err := boltBucket(tx, key, _BOLT_HASH, true)
+		if err != nil {
+			return err
+		}
+		return b.Put([]byte(field), []byte(value))
+	})
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) HDel(key string, fields ...string) error
+ *
+ */
+func (bs *boltStorage) HDel(key string, fields ...string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		b, err := boltBucket(tx, key, _BOLT_HASH, false)
+		if err != nil || b == nil {
+			return err
+		}
+		for _, f := range fields {
+			if err := b.Delete([]byte(f)); err != nil {
+				return err
+			}
+		}
+		return boltCleanup(tx, key, b)
+	})
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) HGetAll(key string) (m map[string]string, err error)
+ *
+ */
+func (bs *boltStorage) HGetAll(key string) (m map[string]string, err error) {
+	m = make(map[string]string)
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		b, err := boltBucket(tx, key, _BOLT_HASH, false)
+		if err != nil || b == nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			m[string(k)] = string(v)
+			return nil
+		})
+	})
+	return
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) SAdd(key string, members ...string) error
+ *
+ */
+func (bs *boltStorage) SAdd(key string, members ...string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		b, err := boltBucket(tx, key, _BOLT_SET, true)
+		if err != nil {
+			return err
+		}
+		for _, m := range members {
+			if err := b.Put([]byte(m), boltTrue); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) SRem(key string, members ...string) error
+ *
+ */
+func (bs *boltStorage) SRem(key string, members ...string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		b, err := boltBucket(tx, key, _BOLT_SET, false)
+		if err != nil || b == nil {
+			return err
+		}
+		for _, m := range members {
+			if err := b.Delete([]byte(m)); err != nil {
+				return err
+			}
+		}
+		return boltCleanup(tx, key, b)
+	})
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) SMembers(key string) (members []string, err error)
+ *
+ */
+func (bs *boltStorage) SMembers(key string) (members []string, err error) {
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		b, err := boltBucket(tx, key, _BOLT_SET, false)
+		if err != nil || b == nil {
+			return err
+		}
+		return b.ForEach(func(k, v []byte) error {
+			members = append(members, string(k))
+			return nil
+		})
+	})
+	return
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) SIsMember(key, member string) (ok bool, err error)
+ *
+ */
+func (bs *boltStorage) SIsMember(key, member string) (ok bool, err error) {
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		b, err := boltBucket(tx, key, _BOLT_SET, false)
+		if err != nil || b == nil {
+			return err
+		}
+		ok = b.Get([]byte(member)) != nil
+		return nil
+	})
+	return
+}
+
+/* }}} */
+
+/* {{{ func decodeLock(b []byte) (owner string, expireAt int64)
+ * 格式: 过期时间(8) owner
+ */
+func decodeLock(b []byte) (owner string, expireAt int64) {
+	if len(b) < 8 {
+		return "", 0
+	}
+	return string(b[8:]), int64(binary.BigEndian.Uint64(b[:8]))
+}
+
+/* }}} */
+
+/* {{{ func encodeLock(owner string, ttl int) []byte
+ *
+ */
+func encodeLock(owner string, ttl int) []byte {
+	b := make([]byte, 8+len(owner))
+	binary.BigEndian.PutUint64(b[:8], uint64(time.Now().Unix()+int64(ttl)))
+	copy(b[8:], owner)
+	return b
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) Lock(key, owner string, ttl int) (fence int64, err error)
+ * 与redis的_LOCK_SCRIPT一致
+ */
+func (bs *boltStorage) Lock(key, owner string, ttl int) (fence int64, err error) {
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		k := []byte(key)
+		lb, fb := tx.Bucket(bucketLock), tx.Bucket(bucketFence)
+		cur, expireAt := decodeLock(lb.Get(k))
+		held := cur != "" && expireAt > time.Now().Unix()
+		if held && cur != owner {
+			return ErrConflict
+		}
+		if fv := fb.Get(k); len(fv) == 8 {
+			fence = int64(binary.BigEndian.Uint64(fv))
+		}
+		if !held { //新加锁, fencing token加1
+			fence++
+			fv := make([]byte, 8)
+			binary.BigEndian.PutUint64(fv, uint64(fence))
+			if err := fb.Put(k, fv); err != nil {
+				return err
+			}
+		}
+		return lb.Put(k, encodeLock(owner, ttl))
+	})
+	return
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) Unlock(key, owner string) error
+ *
+ */
+func (bs *boltStorage) Unlock(key, owner string) error {
+	return bs.db.Update(func(tx *bolt.Tx) error {
+		k := []byte(key)
+		lb := tx.Bucket(bucketLock)
+		cur, expireAt := decodeLock(lb.Get(k))
+		if cur == "" || expireAt <= time.Now().Unix() {
+			return ErrNil
+		} else if cur != owner {
+			return ErrConflict
+		}
+		return lb.Delete(k)
+	})
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) Renew(key, owner string, ttl int) (fence int64, err error)
+ *
+ */
+func (bs *boltStorage) Renew(key, owner string, ttl int) (fence int64, err error) {
+	err = bs.db.Update(func(tx *bolt.Tx) error {
+		k := []byte(key)
+		lb := tx.Bucket(bucketLock)
+		cur, expireAt := decodeLock(lb.Get(k))
+		if cur == "" || expireAt <= time.Now().Unix() {
+			return ErrNil
+		} else if cur != owner {
+			return ErrConflict
+		}
+		if fv := tx.Bucket(bucketFence).Get(k); len(fv) == 8 {
+			fence = int64(binary.BigEndian.Uint64(fv))
+		}
+		return lb.Put(k, encodeLock(owner, ttl))
+	})
+	return
+}
+
+/* }}} */
+
+/* {{{ func (bs *boltStorage) Scan(pattern, cursor string, count int64) (next string, keys []string, err error)
+ * 按key顺序遍历, cursor为下一个key(hex)
+ */
+func (bs *boltStorage) Scan(pattern, cursor string, count int64) (next string, keys []string, err error) {
+	var start []byte
+	if cursor != "0" {
+		if start, err = hex.DecodeString(cursor); err != nil {
+			return "", nil, fmt.Errorf("invalid cursor: %s", cursor)
+		}
+	}
+	next = "0"
+	err = bs.db.View(func(tx *bolt.Tx) error {
+		now := time.Now().Unix()
+		c := tx.Bucket(bucketKV).Cursor()
+		k, v := c.First()
+		if start != nil {
+			k, v = c.Seek(start)
+		}
+		for n := int64(0); k != nil; k, v = c.Next() {
+			if n >= count {
+				next = hex.EncodeToString(k)
+				break
+			}
+			n++
+			if r := decodeRecord(v); r != nil && !r.expired(now) && globMatch(pattern, string(k)) {
+				keys = append(keys, string(k))
+			}
+		}
+		return nil
+	})
+	return
+}
+
+/* }}} */
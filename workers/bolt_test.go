@@ -0,0 +1,130 @@
+//go:build !race
+// +build !race
+
+package workers
+
+import (
+	"bytes"
+	"math"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// boltdb/bolt在-race(checkptr)下创建bucket会崩溃, 本文件不参与-race测试
+func openTestBolt(t *testing.T) *boltStorage {
+	boltPath = filepath.Join(t.TempDir(), "omq.bolt")
+	boltStoreLock.Lock()
+	boltStore = nil
+	boltStoreLock.Unlock()
+	s, err := openBolt(&StorageOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bs := s.(*boltStorage)
+	t.Cleanup(func() {
+		boltStoreLock.Lock()
+		boltStore = nil
+		boltStoreLock.Unlock()
+		bs.db.Close()
+	})
+	return bs
+}
+
+func TestBoltScore(t *testing.T) {
+	// 编码之后的字节序与数值大小一致, 负数排在前面
+	tss := []int64{math.MinInt64, -100, -1, 0, 1, 1700000000, math.MaxInt64}
+	for i, ts := range tss {
+		if got := boltTs(boltScore(ts)); got != ts {
+			t.Fatalf("decode %d: %d", ts, got)
+		}
+		if i > 0 && bytes.Compare(boltScore(tss[i-1]), boltScore(ts)) >= 0 {
+			t.Fatalf("%d encoded after %d", tss[i-1], ts)
+		}
+	}
+}
+
+func TestBoltClaim(t *testing.T) {
+	bs := openTestBolt(t)
+	bs.Schedule("q", "c", 30)
+	bs.Schedule("q", "a", 10)
+	bs.Schedule("q", "b", -5)
+	bs.Schedule("q", "later", 100)
+	bs.Schedule("q", "a", 20) //修改时间
+
+	members, scores, err := bs.Schedules("q", "-inf", "+inf", 0)
+	if err != nil || len(members) != 4 {
+		t.Fatalf("schedules %q, %v", members, err)
+	}
+	if members[0] != "b" || scores[0] != "-5" || members[1] != "a" || scores[1] != "20" {
+		t.Fatalf("schedules %q %q", members, scores)
+	}
+
+	members, scores, err = bs.Claim("q", 30, 2)
+	if err != nil || len(members) != 2 || members[0] != "b" || members[1] != "a" || scores[1] != "20" {
+		t.Fatalf("claim %q %q, %v", members, scores, err)
+	}
+	if members, _, _ = bs.Claim("q", 30, 10); len(members) != 1 || members[0] != "c" {
+		t.Fatalf("second claim %q", members)
+	}
+	if members, _, _ = bs.Claim("q", 30, 10); len(members) != 0 {
+		t.Fatalf("claimed not due %q", members)
+	}
+	if members, _, _ = bs.Schedules("q", "-inf", "+inf", 0); len(members) != 1 || members[0] != "later" {
+		t.Fatalf("left %q", members)
+	}
+}
+
+func TestBoltSchedulesRange(t *testing.T) {
+	bs := openTestBolt(t)
+	for i := 1; i <= 5; i++ {
+		bs.Schedule("q", strconv.Itoa(i), i*10)
+	}
+	for _, c := range []struct {
+		min, max string
+		want     string
+	}{
+		{"10", "30", "123"},
+		{"(10", "30", "23"},
+		{"10", "(30", "12"},
+		{"(10", "(30", "2"},
+		{"(10.5", "(29.5", "2"},
+		{"-inf", "(20", "1"},
+		{"(40", "+inf", "5"},
+		{"(50", "+inf", ""},
+	} {
+		members, _, err := bs.Schedules("q", c.min, c.max, 0)
+		got := ""
+		for _, m := range members {
+			got += m
+		}
+		if err != nil || got != c.want {
+			t.Errorf("[%s, %s]: %q, %v, want %q", c.min, c.max, got, err, c.want)
+		}
+	}
+	if _, _, err := bs.Schedules("q", "x", "+inf", 0); err == nil {
+		t.Fatal("invalid min accepted")
+	}
+}
+
+func TestParseScore(t *testing.T) {
+	for _, c := range []struct {
+		s    string
+		min  bool
+		want int64
+	}{
+		{"-inf", true, math.MinInt64},
+		{"+inf", false, math.MaxInt64},
+		{"10", true, 10},
+		{"(10", true, 11},
+		{"(10", false, 9},
+		{"10.5", true, 11},
+		{"10.5", false, 10},
+		{"(10.5", true, 11},
+		{"(10.5", false, 10},
+	} {
+		if v, err := parseScore(c.s, c.min); err != nil || v != c.want {
+			t.Errorf("parseScore(%q, %v) = %d, %v, want %d", c.s, c.min, v, err, c.want)
+		}
+	}
+}
@@ -0,0 +1,167 @@
+package workers
+
+import (
+	"container/list"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * GET结果的进程内LRU缓存, 配置get_cache(条数)大于0时启用, 每条最多缓存get_cache_ttl秒
+ * 本地以及其他机房同步过来的修改都会使对应key失效
+ */
+
+type cacheEntry struct {
+	key      string
+	value    string
+	expireAt time.Time
+}
+
+type getCache struct {
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	lru     list.List //最近使用的在前
+	gen     uint64    //每次失效加1, 读取存储期间有修改的结果不缓存
+
+	hits      int64
+	misses    int64
+	evictions int64
+}
+
+var gcache = &getCache{entries: make(map[string]*list.Element)}
+
+/* {{{ func cacheKey(ls *LocalStorage, key string) string
+ * 不同存储的同名key分开缓存
+ */
+func cacheKey(ls *LocalStorage, key string) string {
+	opt := StorageOption{}
+	if ls.option != nil {
+		opt = *ls.option
+	}
+	if opt.Type == "" {
+		opt.Type = storageType
+	}
+	return storageKey(&opt) + "|" + key
+}
+
+/* }}} */
+
+/* {{{ func (c *getCache) get(ls *LocalStorage) (string, uint64, bool)
+ * 同时返回当前的generation, 没有命中时用于put
+ */
+func (c *getCache) get(ls *LocalStorage) (string, uint64, bool) {
+	if getCacheSize <= 0 {
+		return "", 0, false
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if e, ok := c.entries[cacheKey(ls, ls.key)]; ok {
+		ce := e.Value.(*cacheEntry)
+		if time.Now().Before(ce.expireAt) {
+			c.hits++
+			c.lru.MoveToFront(e)
+			return ce.value, c.gen, true
+		}
+		c.remove(e)
+	}
+	c.misses++
+	return "", c.gen, false
+}
+
+/* }}} */
+
+/* {{{ func (c *getCache) put(ls *LocalStorage, value string, ttl int64, gen uint64)
+ * ttl为key的剩余秒数(-1为不过期), 缓存不超过它
+ * journal中有未重放的写命令时存储里是旧值, 不缓存
+ */
+func (c *getCache) put(ls *LocalStorage, value string, ttl int64, gen uint64) {
+	if getCacheSize <= 0 || atomic.LoadInt64(&journal.pending) > 0 {
+		return
+	}
+	cacheTTL := int64(getCacheTTL)
+	if ttl >= 0 && ttl < cacheTTL {
+		cacheTTL = ttl
+	}
+	if cacheTTL <= 0 {
+		return
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if gen != c.gen { //读取期间有修改
+		return
+	}
+	key := cacheKey(ls, ls.key)
+	expireAt := time.Now().Add(time.Duration(cacheTTL) * time.Second)
+	if e, ok := c.entries[key]; ok {
+		ce := e.Value.(*cacheEntry)
+		ce.value, ce.expireAt = value, expireAt
+		c.lru.MoveToFront(e)
+		return
+	}
+	c.entries[key] = c.lru.PushFront(&cacheEntry{key: key, value: value, expireAt: expireAt})
+	for c.lru.Len() > getCacheSize {
+		c.remove(c.lru.Back())
+		c.evictions++
+	}
+}
+
+/* }}} */
+
+/* {{{ func (c *getCache) remove(e *list.Element)
+ * 调用者持有锁
+ */
+func (c *getCache) remove(e *list.Element) {
+	c.lru.Remove(e)
+	delete(c.entries, e.Value.(*cacheEntry).key)
+}
+
+/* }}} */
+
+/* {{{ func (c *getCache) invalidate(act string, ls *LocalStorage)
+ * 修改命令使key失效, MSET的每个key都失效
+ */
+func (c *getCache) invalidate(act string, ls *LocalStorage) {
+	if getCacheSize <= 0 {
+		return
+	}
+	keys := []string{ls.key}
+	if act == COMMAND_MSET {
+		for i := 1; i < len(ls.args); i += 2 {
+			keys = append(keys, ls.args[i])
+		}
+	}
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.gen++
+	for _, k := range keys {
+		if e, ok := c.entries[cacheKey(ls, k)]; ok {
+			c.remove(e)
+		}
+	}
+}
+
+/* }}} */
+
+/* {{{ func (c *getCache) stats() []string
+ * 命中, 未命中, 命中率, 当前条数, 淘汰数
+ */
+func (c *getCache) stats() []string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	rate := 0.0
+	if total := c.hits + c.misses; total > 0 {
+		rate = float64(c.hits) / float64(total)
+	}
+	return []string{
+		strconv.FormatInt(c.hits, 10),
+		strconv.FormatInt(c.misses, 10),
+		fmt.Sprintf("%.4f", rate),
+		strconv.Itoa(c.lru.Len()),
+		strconv.FormatInt(c.evictions, 10),
+	}
+}
+
+/* }}} */
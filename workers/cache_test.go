@@ -0,0 +1,128 @@
+package workers
+
+import (
+	"container/list"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 每个测试使用新的内存存储以及空的缓存
+func newTestWorker(t *testing.T) *OmqWorker {
+	storageType = _STORAGE_MEMORY
+	memStoreLock.Lock()
+	memStore = newMemStorage()
+	memStoreLock.Unlock()
+	storagesLock.Lock()
+	storages = make(map[string]Storage)
+	connectFailures = make(map[string]*connectFailure)
+	storagesLock.Unlock()
+	storagePool = 64
+	gcache = &getCache{entries: make(map[string]*list.Element)}
+	breaker = &storageBreaker{}
+	journal = &writeJournal{}
+	journalPath = ""
+	breakerFailures, breakerCooldown = 5, 10
+	getCacheSize, getCacheTTL = 0, 5
+	tasks = newTaskRegistry()
+	taskRetention = 60
+	return &OmqWorker{}
+}
+
+func mustGet(t *testing.T, w *OmqWorker, key string) string {
+	r, err := w.localGet([]string{COMMAND_GET, "", key})
+	if err != nil {
+		t.Fatalf("get %s: %v", key, err)
+	}
+	return r[0]
+}
+
+func TestCacheInvalidate(t *testing.T) {
+	w := newTestWorker(t)
+	getCacheSize = 10
+	if _, err := w.localStorage([]string{COMMAND_SET, "", "k", "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	if v := mustGet(t, w, "k"); v != "v1" {
+		t.Fatalf("get %q", v)
+	}
+	if v := mustGet(t, w, "k"); v != "v1" {
+		t.Fatalf("cached get %q", v)
+	}
+	if gcache.hits != 1 || gcache.misses != 1 {
+		t.Fatalf("hits %d, misses %d", gcache.hits, gcache.misses)
+	}
+
+	// 本地以及复制过来的修改都经过storeLocal
+	if _, err := w.localStorage([]string{COMMAND_SET, "", "k", "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if v := mustGet(t, w, "k"); v != "v2" {
+		t.Fatalf("get after set %q", v)
+	}
+	if _, err := w.localStorage([]string{COMMAND_MSET, "", "a", "1", "k", "v3"}); err != nil {
+		t.Fatal(err)
+	}
+	if v := mustGet(t, w, "k"); v != "v3" {
+		t.Fatalf("get after mset %q", v)
+	}
+}
+
+func TestCacheTTL(t *testing.T) {
+	w := newTestWorker(t)
+	getCacheSize = 10
+	if _, err := w.localStorage([]string{COMMAND_SET, "", "short", "v", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.localStorage([]string{COMMAND_SET, "", "long", "v"}); err != nil {
+		t.Fatal(err)
+	}
+	mustGet(t, w, "short")
+	mustGet(t, w, "long")
+
+	ls := w.newLocalStorage([]string{COMMAND_GET, "", "short"})
+	e, ok := gcache.entries[cacheKey(ls, "short")]
+	if !ok {
+		t.Fatal("short not cached")
+	}
+	if left := time.Until(e.Value.(*cacheEntry).expireAt); left > 2*time.Second {
+		t.Fatalf("cached %s, longer than key ttl", left)
+	}
+	e, ok = gcache.entries[cacheKey(ls, "long")]
+	if !ok {
+		t.Fatal("long not cached")
+	}
+	if left := time.Until(e.Value.(*cacheEntry).expireAt); left <= 2*time.Second {
+		t.Fatalf("cached %s, want get_cache_ttl", left)
+	}
+}
+
+func TestCacheJournalInvalidate(t *testing.T) {
+	w := newTestWorker(t)
+	getCacheSize = 10
+	journalPath = filepath.Join(t.TempDir(), "journal")
+	if _, err := w.localStorage([]string{COMMAND_SET, "", "k", "v1"}); err != nil {
+		t.Fatal(err)
+	}
+	mustGet(t, w, "k")
+
+	// 存储不可用, 写入journal时缓存就失效, 重放之前也不再缓存旧值
+	breaker.openUntil = time.Now().Add(time.Minute)
+	if _, err := w.localStorage([]string{COMMAND_SET, "", "k", "v2"}); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt64(&journal.pending) != 1 {
+		t.Fatalf("pending %d", journal.pending)
+	}
+	if gcache.lru.Len() != 0 {
+		t.Fatal("cache not invalidated on journal append")
+	}
+	breaker.openUntil = time.Time{}
+	if v := mustGet(t, w, "k"); v != "v1" {
+		t.Fatalf("get before replay %q", v)
+	}
+	if gcache.lru.Len() != 0 {
+		t.Fatal("cached while journal pending")
+	}
+}
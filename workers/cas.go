@@ -0,0 +1,89 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	CAS_VALUE   = "VALUE"   //当前值等于期望值
+	CAS_VERSION = "VERSION" //当前版本等于期望版本
+	CAS_NX      = "NX"      //不存在才写入
+	CAS_XX      = "XX"      //存在才写入
+)
+
+var ErrConflict = errors.New(RESPONSE_CONFLICT)
+
+/* {{{ func (ls *LocalStorage) CAS() (r []string, err error)
+ * 条件写入, 参数为: 模式, 期望值, 过期秒数; 返回新版本号, 条件不满足返回ErrConflict
+ */
+func (ls *LocalStorage) CAS() (r []string, err error) {
+	mode, expected, expire := "", "", 0
+	if len(ls.args) > 1 {
+		mode = strings.ToUpper(ls.args[1])
+	}
+	if len(ls.args) > 2 {
+		expected = ls.args[2]
+	}
+	if len(ls.args) > 3 {
+		expire, _ = strconv.Atoi(ls.args[3])
+	}
+	switch mode {
+	case CAS_VALUE, CAS_NX, CAS_XX:
+	case CAS_VERSION:
+		if _, e := strconv.ParseInt(expected, 10, 64); e != nil {
+			return nil, fmt.Errorf("version error: %s", expected)
+		}
+	default:
+		return nil, fmt.Errorf("unknown cas mode: %s", mode)
+	}
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	if mode == CAS_VALUE { //存的可能是编码后的值, 解码后比较, 再以原值作为条件写入
+		var cur string
+		if cur, err = s.Get(ls.key); err == nil {
+			var plain string
+			if plain, err = decodeValue(cur); err != nil {
+				return
+			} else if plain == expected {
+				expected = cur
+			} else if plain != cur {
+				return nil, ErrConflict
+			}
+		} else if err != ErrNil {
+			return
+		}
+		err = nil
+	}
+	var ver int64
+	if ver, err = s.CAS(ls.key, ls.value, mode, expected, expire); err == nil {
+		r = []string{strconv.FormatInt(ver, 10)}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) GetV() (r []string, err error)
+ * 返回value以及版本号
+ */
+func (ls *LocalStorage) GetV() (r []string, err error) {
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	var v string
+	var ver int64
+	if v, ver, err = s.GetV(ls.key); err == nil {
+		if v, err = decodeValue(v); err == nil {
+			r = []string{v, strconv.FormatInt(ver, 10)}
+		}
+	}
+	return
+}
+
+/* }}} */
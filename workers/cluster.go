@@ -0,0 +1,382 @@
+package workers
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Odinman/ogo"
+	"gopkg.in/redis.v3"
+)
+
+type clusterStorage struct {
+	redisScripts
+	c *redis.ClusterClient
+
+	masters     map[string]*redis.Client //各master的连接, 用于SCAN
+	mastersLock sync.Mutex
+}
+
+var (
+	clusterStore *clusterStorage //集群只有一个
+	clusterLock  sync.Mutex
+)
+
+func init() {
+	RegisterStorage(_STORAGE_CLUSTER, openCluster)
+}
+
+/* {{{ func openCluster(opt *StorageOption) (Storage, error)
+ *
+ */
+func openCluster(opt *StorageOption) (Storage, error) {
+	clusterLock.Lock()
+	defer clusterLock.Unlock()
+	if clusterStore != nil {
+		return clusterStore, nil
+	}
+	c := ogo.ClusterClient()
+	if c == nil {
+		return nil, fmt.Errorf("not found cluster")
+	}
+	cs := &clusterStorage{c: c, masters: make(map[string]*redis.Client)}
+	cs.eval = cs.evalScript
+	clusterStore = cs
+	return cs, nil
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) evalScript(script string, keys []string, args ...string) (interface{}, error)
+ *
+ */
+func (cs *clusterStorage) evalScript(script string, keys []string, args ...string) (interface{}, error) {
+	r, err := cs.c.Eval(script, keys, args).Result()
+	if err == redis.Nil {
+		return nil, ErrNil
+	}
+	return r, err
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) Get(key string) (string, error)
+ *
+ */
+func (cs *clusterStorage) Get(key string) (string, error) {
+	r, err := cs.c.Get(key).Result()
+	if err == redis.Nil {
+		return "", ErrNil
+	}
+	return r, err
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) Del(key string) (err error)
+ * 版本号一起删除
+ */
+func (cs *clusterStorage) Del(key string) (err error) {
+	if err = cs.c.Del(key).Err(); err == nil {
+		err = cs.c.Del(versionKey(key)).Err()
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) Schedule(key, member string, ts int) error
+ *
+ */
+func (cs *clusterStorage) Schedule(key, member string, ts int) error {
+	return cs.c.ZAdd(key, redis.Z{Score: float64(ts), Member: member}).Err()
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) Unschedule(key string, members ...string) error
+ *
+ */
+func (cs *clusterStorage) Unschedule(key string, members ...string) error {
+	return cs.c.ZRem(key, members...).Err()
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) Schedules(key, min, max string, count int) (members, scores []string, err error)
+ *
+ */
+func (cs *clusterStorage) Schedules(key, min, max string, count int) (members, scores []string, err error) {
+	opt := redis.ZRangeByScore{Min: min, Max: max}
+	if count > 0 {
+		opt.Count = int64(count)
+	}
+	var rz []redis.Z
+	if rz, err = cs.c.ZRangeByScoreWithScores(key, opt).Result(); err != nil {
+		return
+	}
+	for _, z := range rz {
+		members = append(members, fmt.Sprint(z.Member))
+		scores = append(scores, strconv.FormatFloat(z.Score, 'f', -1, 64))
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) Exists(key string) (bool, error)
+ *
+ */
+func (cs *clusterStorage) Exists(key string) (bool, error) {
+	return cs.c.Exists(key).Result()
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) TTL(key string) (int64, error)
+ *
+ */
+func (cs *clusterStorage) TTL(key string) (int64, error) {
+	d, err := cs.c.TTL(key).Result()
+	if err != nil {
+		return 0, err
+	}
+	var ttl int64
+	if d < 0 {
+		ttl = int64(d / time.Second)
+	} else {
+		ttl = int64(d.Seconds())
+	}
+	if ttl == -2 {
+		return 0, ErrNil
+	}
+	return ttl, nil
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) Expire(key string, expire int) (err error)
+ * 版本号与值同时过期
+ */
+func (cs *clusterStorage) Expire(key string, expire int) (err error) {
+	var ok bool
+	if ok, err = cs.expireKey(key, expire); err == nil && !ok {
+		err = ErrNil
+	} else if err == nil {
+		_, err = cs.expireKey(versionKey(key), expire)
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) expireKey(key string, expire int) (ok bool, err error)
+ * key不存在时ok为false
+ */
+func (cs *clusterStorage) expireKey(key string, expire int) (ok bool, err error) {
+	if expire > 0 {
+		return cs.c.Expire(key, time.Duration(expire)*time.Second).Result()
+	}
+	if ok, err = cs.c.Exists(key).Result(); ok && err == nil {
+		err = cs.c.Persist(key).Err()
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) MGet(keys ...string) (r []string, err error)
+ * 多个key可能不在同一个slot, 逐个获取
+ */
+func (cs *clusterStorage) MGet(keys ...string) (r []string, err error) {
+	r = make([]string, len(keys))
+	for i, k := range keys {
+		if v, e := cs.c.Get(k).Result(); e == nil {
+			r[i] = v
+		} else if e != redis.Nil {
+			return nil, e
+		}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) MSet(pairs ...string) (err error)
+ * 逐个设置
+ */
+func (cs *clusterStorage) MSet(pairs ...string) (err error) {
+	for i := 0; i+1 < len(pairs); i += 2 {
+		if err = cs.Set(pairs[i], pairs[i+1], 0); err != nil {
+			return
+		}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) HGet(key, field string) (string, error)
+ *
+ */
+func (cs *clusterStorage) HGet(key, field string) (string, error) {
+	r, err := cs.c.HGet(key, field).Result()
+	if err == redis.Nil {
+		return "", ErrNil
+	}
+	return r, err
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) HSet(key, field, value string) error
+ *
+ */
+func (cs *clusterStorage) HSet(key, field, value string) error {
+	return cs.c.HSet(key, field, value).Err()
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) HDel(key string, fields ...string) error
+ *
+ */
+func (cs *clusterStorage) HDel(key string, fields ...string) error {
+	return cs.c.HDel(key, fields...).Err()
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) HGetAll(key string) (map[string]string, error)
+ *
+ */
+func (cs *clusterStorage) HGetAll(key string) (map[string]string, error) {
+	return cs.c.HGetAllMap(key).Result()
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) SAdd(key string, members ...string) error
+ *
+ */
+func (cs *clusterStorage) SAdd(key string, members ...string) error {
+	return cs.c.SAdd(key, members...).Err()
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) SRem(key string, members ...string) error
+ *
+ */
+func (cs *clusterStorage) SRem(key string, members ...string) error {
+	return cs.c.SRem(key, members...).Err()
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) SMembers(key string) ([]string, error)
+ *
+ */
+func (cs *clusterStorage) SMembers(key string) ([]string, error) {
+	return cs.c.SMembers(key).Result()
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) SIsMember(key, member string) (bool, error)
+ *
+ */
+func (cs *clusterStorage) SIsMember(key, member string) (bool, error) {
+	return cs.c.SIsMember(key, member).Result()
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) Scan(pattern, cursor string, count int64) (next string, keys []string, err error)
+ * 依次遍历每个master, cursor格式为"master序号:cursor"
+ */
+func (cs *clusterStorage) Scan(pattern, cursor string, count int64) (next string, keys []string, err error) {
+	idx, cur := 0, int64(0)
+	if cursor != "0" {
+		parts := strings.SplitN(cursor, ":", 2)
+		if len(parts) != 2 {
+			return "", nil, fmt.Errorf("invalid cursor: %s", cursor)
+		}
+		if idx, err = strconv.Atoi(parts[0]); err != nil {
+			return "", nil, fmt.Errorf("invalid cursor: %s", cursor)
+		}
+		if cur, err = strconv.ParseInt(parts[1], 10, 64); err != nil {
+			return "", nil, fmt.Errorf("invalid cursor: %s", cursor)
+		}
+	}
+	var addrs []string
+	if addrs, err = cs.clusterMasters(); err != nil {
+		return
+	}
+	if idx >= len(addrs) { //master减少了, 结束
+		return "0", nil, nil
+	}
+	var nc int64
+	if nc, keys, err = cs.masterClient(addrs[idx]).Scan(cur, pattern, count).Result(); err != nil {
+		return
+	}
+	switch {
+	case nc != 0:
+		next = fmt.Sprint(idx, ":", nc)
+	case idx+1 < len(addrs):
+		next = fmt.Sprint(idx+1, ":0")
+	default:
+		next = "0"
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) clusterMasters() ([]string, error)
+ * 集群所有master地址(排序, 保证cursor稳定)
+ */
+func (cs *clusterStorage) clusterMasters() ([]string, error) {
+	nodes, err := cs.c.ClusterNodes().Result()
+	if err != nil {
+		return nil, err
+	}
+	addrs := make([]string, 0)
+	for _, line := range strings.Split(nodes, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+		if !strings.Contains(fields[2], "master") || strings.Contains(fields[2], "fail") {
+			continue
+		}
+		addr := fields[1]
+		if i := strings.Index(addr, "@"); i > 0 { //redis 4以后带cluster bus端口
+			addr = addr[:i]
+		}
+		addrs = append(addrs, addr)
+	}
+	sort.Strings(addrs)
+	return addrs, nil
+}
+
+/* }}} */
+
+/* {{{ func (cs *clusterStorage) masterClient(addr string) *redis.Client
+ *
+ */
+func (cs *clusterStorage) masterClient(addr string) *redis.Client {
+	cs.mastersLock.Lock()
+	defer cs.mastersLock.Unlock()
+	if c, ok := cs.masters[addr]; ok {
+		return c
+	}
+	c := redis.NewClient(&redis.Options{Addr: addr, Password: redisPwd})
+	cs.masters[addr] = c
+	return c
+}
+
+/* }}} */
@@ -0,0 +1,321 @@
+package workers
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/golang/snappy"
+)
+
+/*
+ * 值的压缩与加密, 由StorageOption.Compress(gzip/snappy)以及Encrypt(密钥id)指定
+ * 编码后的值自带头部: magic, 压缩方式, 密钥id, 读取时不需要选项即可解码
+ * 密钥来自keyring文件, 每行: id=base64(16/24/32字节的AES密钥)
+ * 复制到其他机房的是编码后的值, 其他机房原样保存(需要相同的keyring才能读取)
+ */
+
+const (
+	_CODEC_MAGIC = "\x00OMQ\x01"
+
+	_COMPRESS_NONE   byte = 0
+	_COMPRESS_GZIP   byte = 1
+	_COMPRESS_SNAPPY byte = 2
+)
+
+var (
+	keyring     map[string][]byte //密钥id => 密钥
+	keyringLock sync.Mutex
+)
+
+/* {{{ func loadKeyring() (map[string][]byte, error)
+ * 第一次使用时读取keyring文件
+ */
+func loadKeyring() (map[string][]byte, error) {
+	keyringLock.Lock()
+	defer keyringLock.Unlock()
+	if keyring != nil {
+		return keyring, nil
+	}
+	if keyringPath == "" {
+		return nil, fmt.Errorf("keyring not configured")
+	}
+	f, err := os.Open(keyringPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	keys := make(map[string][]byte)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("keyring line error: %s", line)
+		}
+		id := strings.TrimSpace(kv[0])
+		key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("keyring key %s: %s", id, err)
+		} else if l := len(key); l != 16 && l != 24 && l != 32 {
+			return nil, fmt.Errorf("keyring key %s: invalid length %d", id, l)
+		} else if len(id) == 0 || len(id) > 255 {
+			return nil, fmt.Errorf("keyring id error: %s", id)
+		}
+		keys[id] = key
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	keyring = keys
+	return keyring, nil
+}
+
+/* }}} */
+
+/* {{{ func keyringGet(id string) ([]byte, error)
+ *
+ */
+func keyringGet(id string) ([]byte, error) {
+	keys, err := loadKeyring()
+	if err != nil {
+		return nil, err
+	}
+	key, ok := keys[id]
+	if !ok {
+		return nil, fmt.Errorf("key not in keyring: %s", id)
+	}
+	return key, nil
+}
+
+/* }}} */
+
+/* {{{ func compressMethod(name string) (byte, error)
+ *
+ */
+func compressMethod(name string) (byte, error) {
+	switch strings.ToLower(name) {
+	case "":
+		return _COMPRESS_NONE, nil
+	case "gzip":
+		return _COMPRESS_GZIP, nil
+	case "snappy":
+		return _COMPRESS_SNAPPY, nil
+	}
+	return 0, fmt.Errorf("compress not supported: %s", name)
+}
+
+/* }}} */
+
+/* {{{ func needEncode(opt *StorageOption) bool
+ *
+ */
+func needEncode(opt *StorageOption) bool {
+	return opt != nil && (opt.Compress != "" || opt.Encrypt != "")
+}
+
+/* }}} */
+
+/* {{{ func isEncoded(v string) bool
+ *
+ */
+func isEncoded(v string) bool {
+	return strings.HasPrefix(v, _CODEC_MAGIC)
+}
+
+/* }}} */
+
+/* {{{ func encodeValue(opt *StorageOption, v string, stable bool) (string, error)
+ * stable为true时相同的值编码结果相同(定时的member需要按值取消), 否则每次使用随机nonce
+ * 已经编码的值(来自其他机房)原样返回
+ */
+func encodeValue(opt *StorageOption, v string, stable bool) (string, error) {
+	if !needEncode(opt) || isEncoded(v) {
+		return v, nil
+	}
+	method, err := compressMethod(opt.Compress)
+	if err != nil {
+		return "", err
+	}
+	data := []byte(v)
+	switch method {
+	case _COMPRESS_GZIP:
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err = zw.Write(data); err == nil {
+			err = zw.Close()
+		}
+		if err != nil {
+			return "", err
+		}
+		data = buf.Bytes()
+	case _COMPRESS_SNAPPY:
+		data = snappy.Encode(nil, data)
+	}
+	if opt.Encrypt != "" {
+		if len(opt.Encrypt) > 255 {
+			return "", fmt.Errorf("encrypt key id too long")
+		}
+		key, err := keyringGet(opt.Encrypt)
+		if err != nil {
+			return "", err
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return "", err
+		}
+		nonce := make([]byte, gcm.NonceSize())
+		if stable { //nonce由内容决定, 使用派生的子密钥, 不直接用加密密钥
+			mac := hmac.New(sha256.New, nonceKey(key))
+			mac.Write(data)
+			copy(nonce, mac.Sum(nil))
+		} else if _, err = rand.Read(nonce); err != nil {
+			return "", err
+		}
+		data = gcm.Seal(nonce, nonce, data, []byte(opt.Encrypt))
+	}
+	header := []byte(_CODEC_MAGIC)
+	header = append(header, method, byte(len(opt.Encrypt)))
+	header = append(header, opt.Encrypt...)
+	return string(append(header, data...)), nil
+}
+
+/* }}} */
+
+/* {{{ func decodeValue(v string) (string, error)
+ * 没有编码的值原样返回
+ */
+func decodeValue(v string) (string, error) {
+	if !isEncoded(v) {
+		return v, nil
+	}
+	b := []byte(v[len(_CODEC_MAGIC):])
+	if len(b) < 2 || len(b) < 2+int(b[1]) {
+		return "", fmt.Errorf("encoded value corrupted")
+	}
+	method, id := b[0], string(b[2:2+int(b[1])])
+	data := b[2+int(b[1]):]
+	if id != "" {
+		key, err := keyringGet(id)
+		if err != nil {
+			return "", err
+		}
+		gcm, err := newGCM(key)
+		if err != nil {
+			return "", err
+		}
+		if len(data) < gcm.NonceSize() {
+			return "", fmt.Errorf("encoded value corrupted")
+		}
+		if data, err = gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], []byte(id)); err != nil {
+			return "", err
+		}
+	}
+	switch method {
+	case _COMPRESS_NONE:
+	case _COMPRESS_GZIP:
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return "", err
+		}
+		defer zr.Close()
+		if data, err = ioutil.ReadAll(zr); err != nil {
+			return "", err
+		}
+	case _COMPRESS_SNAPPY:
+		var err error
+		if data, err = snappy.Decode(nil, data); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown compress method: %d", method)
+	}
+	return string(data), nil
+}
+
+/* }}} */
+
+/* {{{ func decodeValues(vs []string) ([]string, error)
+ * 原地解码
+ */
+func decodeValues(vs []string) ([]string, error) {
+	for i, v := range vs {
+		d, err := decodeValue(v)
+		if err != nil {
+			return nil, err
+		}
+		vs[i] = d
+	}
+	return vs, nil
+}
+
+/* }}} */
+
+/* {{{ func nonceKey(key []byte) []byte
+ * 生成stable nonce用的子密钥: HMAC(key, "nonce")
+ */
+func nonceKey(key []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte("nonce"))
+	return mac.Sum(nil)
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) encodeCommand(cmd []string) ([]string, error)
+ * 写入以及复制(journal, 其他机房)使用编码后的命令, 返回副本, 不修改cmd
+ * SET每次随机nonce, 定时相关的命令需要稳定编码(按值取消)
+ */
+func (w *OmqWorker) encodeCommand(cmd []string) ([]string, error) {
+	if len(cmd) <= 3 {
+		return cmd, nil
+	}
+	stable := false
+	switch strings.ToUpper(cmd[0]) {
+	case COMMAND_SET:
+	case COMMAND_SCHEDULE, COMMAND_UNSCHEDULE, COMMAND_RESCHEDULE:
+		stable = true
+	default:
+		return cmd, nil
+	}
+	ls := w.newLocalStorage(cmd)
+	if !needEncode(ls.option) {
+		return cmd, nil
+	}
+	v, err := encodeValue(ls.option, cmd[3], stable)
+	if err != nil {
+		return nil, err
+	}
+	rep := append([]string(nil), cmd...)
+	rep[3] = v
+	return rep, nil
+}
+
+/* }}} */
+
+/* {{{ func newGCM(key []byte) (cipher.AEAD, error)
+ *
+ */
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+/* }}} */
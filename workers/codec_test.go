@@ -0,0 +1,171 @@
+package workers
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func setTestKeyring(t *testing.T) {
+	keyringPath = filepath.Join(t.TempDir(), "omq.keyring")
+	key := base64.StdEncoding.EncodeToString([]byte(strings.Repeat("k", 32)))
+	if err := ioutil.WriteFile(keyringPath, []byte("k1="+key+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	keyringLock.Lock()
+	keyring = nil
+	keyringLock.Unlock()
+}
+
+func TestCodecRoundTrip(t *testing.T) {
+	setTestKeyring(t)
+	value := strings.Repeat("hello omq ", 100)
+	for _, opt := range []*StorageOption{
+		{},
+		{Compress: "gzip"},
+		{Compress: "snappy"},
+		{Encrypt: "k1"},
+		{Compress: "gzip", Encrypt: "k1"},
+	} {
+		for _, stable := range []bool{false, true} {
+			enc, err := encodeValue(opt, value, stable)
+			if err != nil {
+				t.Fatalf("%+v encode: %v", opt, err)
+			}
+			if needEncode(opt) != isEncoded(enc) {
+				t.Fatalf("%+v encoded: %v", opt, isEncoded(enc))
+			}
+			if opt.Encrypt != "" && strings.Contains(enc, "hello") {
+				t.Fatalf("%+v plaintext in encoded value", opt)
+			}
+			dec, err := decodeValue(enc)
+			if err != nil || dec != value {
+				t.Fatalf("%+v decode: %v", opt, err)
+			}
+			// 已经编码的(来自其他机房)不再编码
+			if again, _ := encodeValue(opt, enc, stable); again != enc {
+				t.Fatalf("%+v encoded twice", opt)
+			}
+		}
+	}
+}
+
+func TestCodecStable(t *testing.T) {
+	setTestKeyring(t)
+	opt := &StorageOption{Encrypt: "k1"}
+	a, _ := encodeValue(opt, "member", true)
+	b, _ := encodeValue(opt, "member", true)
+	if a != b {
+		t.Fatal("stable encoding differs")
+	}
+	c, _ := encodeValue(opt, "member", false)
+	d, _ := encodeValue(opt, "member", false)
+	if c == d {
+		t.Fatal("random nonce repeated")
+	}
+}
+
+func TestCodecErrors(t *testing.T) {
+	setTestKeyring(t)
+	if _, err := encodeValue(&StorageOption{Encrypt: "missing"}, "v", false); err == nil {
+		t.Fatal("unknown key id encoded")
+	}
+	if _, err := encodeValue(&StorageOption{Compress: "zip"}, "v", false); err == nil {
+		t.Fatal("unknown compress method encoded")
+	}
+	enc, _ := encodeValue(&StorageOption{Encrypt: "k1"}, "v", false)
+	if _, err := decodeValue(enc[:len(enc)-1]); err == nil {
+		t.Fatal("truncated value decoded")
+	}
+}
+
+func TestEncodeCommand(t *testing.T) {
+	setTestKeyring(t)
+	w := newTestWorker(t)
+	opt := `{"Encrypt":"k1"}`
+
+	// 不足4帧的原样返回
+	cmd := []string{COMMAND_SET, opt, "k"}
+	if rep, err := w.encodeCommand(cmd); err != nil || len(rep) != 3 {
+		t.Fatalf("short command: %q, %v", rep, err)
+	}
+
+	cmd = []string{COMMAND_SET, opt, "k", "secret"}
+	rep, err := w.localStorage(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cmd[3] != "secret" {
+		t.Fatal("caller's command modified")
+	}
+	if !isEncoded(rep[3]) {
+		t.Fatal("replicated value not encoded")
+	}
+	if v := mustGet(t, w, "k"); v != "secret" {
+		t.Fatalf("get %q", v)
+	}
+
+	// 定时按值取消, 编码需要一致
+	if _, err := w.localStorage([]string{COMMAND_SCHEDULE, opt, "q", "m", "2000000000"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.localStorage([]string{COMMAND_UNSCHEDULE, opt, "q", "m"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.localGet([]string{COMMAND_SCHEDULES, opt, "q"}); err != ErrNil {
+		t.Fatalf("unschedule left members: %v", err)
+	}
+}
+
+func TestCASEncoded(t *testing.T) {
+	setTestKeyring(t)
+	w := newTestWorker(t)
+	opt := `{"Encrypt":"k1"}`
+	if _, err := w.localStorage([]string{COMMAND_SET, opt, "k", "old"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.localGet([]string{COMMAND_CAS, opt, "k", "x", CAS_VALUE, "other"}); err != ErrConflict {
+		t.Fatalf("cas with wrong value: %v", err)
+	}
+	if _, err := w.localGet([]string{COMMAND_CAS, opt, "k", "new", CAS_VALUE, "old"}); err != nil {
+		t.Fatalf("cas with plaintext value: %v", err)
+	}
+	if v := mustGet(t, w, "k"); v != "new" {
+		t.Fatalf("get %q", v)
+	}
+}
+
+func TestTimingUndecodable(t *testing.T) {
+	setTestKeyring(t)
+	w := newTestWorker(t)
+	opt := `{"Encrypt":"k1"}`
+	now := time.Now().Unix()
+	if _, err := w.localStorage([]string{COMMAND_SCHEDULE, opt, "q", "secret", fmt.Sprint(now - 1)}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.localStorage([]string{COMMAND_SCHEDULE, "", "q", "plain", fmt.Sprint(now - 1)}); err != nil {
+		t.Fatal(err)
+	}
+
+	// 密钥轮换后无法解密的放回, 能解码的照常返回
+	keyringPath = filepath.Join(t.TempDir(), "missing")
+	keyringLock.Lock()
+	keyring = nil
+	keyringLock.Unlock()
+	r, err := w.localGet([]string{COMMAND_TIMING, "", "q"})
+	if err != nil || len(r) != 1 || r[0] != "plain" {
+		t.Fatalf("timing %q, %v", r, err)
+	}
+	if _, err := w.localGet([]string{COMMAND_TIMING, "", "q"}); err != ErrNil {
+		t.Fatalf("undecodable member claimed again: %v", err)
+	}
+
+	setTestKeyring(t)
+	if ts := scheduledAt(t, w, "q", "secret"); ts <= now {
+		t.Fatalf("undecodable member dropped or not delayed: %d", ts)
+	}
+}
@@ -2,6 +2,9 @@ package workers
 
 import (
 	"errors"
+	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/Odinman/ogo"
@@ -29,18 +32,73 @@ const (
 	COMMAND_BPOP     = "BPOP"
 	COMMAND_SCHEDULE = "SCHEDULE" //定时任务
 	COMMAND_TIMING   = "TIMING"   //定时触发
+	COMMAND_RTASK    = "RTASK"    //可重试任务
+	COMMAND_RETRY    = "RETRY"    //任务失败, 要求重试
+	COMMAND_POLICY   = "POLICY"   //队列重试策略
+	COMMAND_WORKFLOW = "WORKFLOW" //提交工作流
+	COMMAND_WFSTATUS = "WFSTATUS" //工作流状态
+	COMMAND_STATUS   = "STATUS"   //任务状态
+	COMMAND_SCATTER  = "SCATTER"  //分发到多个队列并等待所有结果
+
+	//key-value扩展
+	COMMAND_EXISTS = "EXISTS"
+	COMMAND_TTL    = "TTL"
+	COMMAND_EXPIRE = "EXPIRE"
+	COMMAND_INCR   = "INCR" //计数器, 返回新值
+	COMMAND_MGET   = "MGET"
+	COMMAND_MSET   = "MSET"
+	COMMAND_GETV   = "GETV" //获取值以及版本号
+	COMMAND_CAS    = "CAS"  //条件写入
+	COMMAND_SCAN   = "SCAN" //按前缀遍历key
+
+	//hash
+	COMMAND_HGET    = "HGET"
+	COMMAND_HSET    = "HSET"
+	COMMAND_HDEL    = "HDEL"
+	COMMAND_HGETALL = "HGETALL"
+
+	//set
+	COMMAND_SADD      = "SADD"
+	COMMAND_SREM      = "SREM"
+	COMMAND_SMEMBERS  = "SMEMBERS"
+	COMMAND_SISMEMBER = "SISMEMBER"
+
+	//分布式锁
+	COMMAND_LOCK   = "LOCK"
+	COMMAND_BLOCK  = "BLOCK" //阻塞加锁
+	COMMAND_UNLOCK = "UNLOCK"
+	COMMAND_RENEW  = "RENEW" //续约
+
+	//定时管理
+	COMMAND_UNSCHEDULE = "UNSCHEDULE" //取消定时
+	COMMAND_RESCHEDULE = "RESCHEDULE" //修改定时时间
+	COMMAND_SCHEDULES  = "SCHEDULES"  //列出定时
+
+	//周期定时
+	COMMAND_RECUR       = "RECUR"       //周期定时
+	COMMAND_RECURDEL    = "RECURDEL"    //删除周期定时
+	COMMAND_RECURPAUSE  = "RECURPAUSE"  //暂停周期定时
+	COMMAND_RECURRESUME = "RECURRESUME" //恢复周期定时
+	COMMAND_RECURLIST   = "RECURLIST"   //列出周期定时
+
+	//运维
+	COMMAND_HEALTH     = "HEALTH"     //存储健康状态
+	COMMAND_CACHESTATS = "CACHESTATS" //GET缓存统计
+	COMMAND_PEERS      = "PEERS"      //订阅的其他机房状态
 
 	//response
-	RESPONSE_OK      = "OK"
-	RESPONSE_ERROR   = "ERROR"
-	RESPONSE_NIL     = "NIL"
-	RESPONSE_UNKNOWN = "UNKNOWN"
+	RESPONSE_OK       = "OK"
+	RESPONSE_ERROR    = "ERROR"
+	RESPONSE_NIL      = "NIL"
+	RESPONSE_UNKNOWN  = "UNKNOWN"
+	RESPONSE_CONFLICT = "CONFLICT" //CAS条件不满足
 )
 
 //config var
 var (
 	basePort      int
 	remotePort    int
+	storageType   string // 默认存储
 	redisAddr     string
 	redisSentinel string
 	redisPwd      string
@@ -48,12 +106,74 @@ var (
 	redisMTag     string
 	pubAddr       string
 	mqBuffer      int
+	nodeId        string // 本节点标识
+
+	// 重试策略(默认)
+	retryMax        int
+	retryBackoff    int
+	retryBackoffMax int
+	retryTimeout    int
+
+	flowRetention int // 工作流结束后保留的秒数
+	taskRetention int // 任务记录保留的秒数
+
+	trackPush bool // PUSH(以及定时触发)的任务是否记录状态, TASK总是记录
+
+	replyTaskId bool // PUSH/TASK回复OK之后是否再带一帧任务id(旧客户端只读一帧OK)
+
+	scatterMaxTimeout int // SCATTER等待结果的最长秒数
+
+	lockTTL int // 锁的默认租约秒数
+
+	watchPort int // 变更通知的PUB端口
+
+	// sql存储
+	sqlDSN           string          // mysql dsn, 例如 user:pwd@tcp(127.0.0.1:3306)/omq
+	sqlitePath       string          // sqlite数据库文件
+	sqlTable         string          // 默认表名
+	sqlTables        map[string]bool // StorageOption可以指定的其他表, table或db.table
+	sqlMaxOpen       int
+	sqlMaxIdle       int
+	sqlConnLifetime  int // 连接最长使用秒数, 0为不限制
+	sqlPurgeInterval int // 清理过期内容的间隔秒数
+
+	boltPath string // 内嵌存储的数据文件
+
+	keyringPath string // 加密密钥文件
+
+	remotePeers     []string // 订阅的其他机房, host:port
+	replicateRelay  bool     // 是否把其他机房的命令转发给自己的订阅者
+	replicateTagged bool     // 复制的命令是否带来源节点以及操作id(所有机房都升级后再打开)
+
+	// GET本地没有时查询的其他机房omq
+	readPeers   []string // host:port
+	readTimeout int      // 毫秒
+
+	// GET缓存
+	getCacheSize int // 最多缓存条数, 0为不缓存
+	getCacheTTL  int // 每条最多缓存秒数
+
+	// 存储不可用时
+	journalPath     string // 写入缓存文件, 为空不缓存
+	breakerFailures int    // 连续失败多少次后熔断
+	breakerCooldown int    // 熔断秒数, 之后重试
+
+	// StorageOption指定的其他redis
+	redisTargets    map[string]bool // 允许的目标, host:port或host:port/db
+	redisTargetPool int             // 最多缓存的连接池数
+
+	storagePool int // 最多连接的存储(不同连接参数)数, 超过的拒绝
+
+	scheduler         bool // 是否由omq触发定时
+	schedulerInterval int
+	schedulerLockTTL  int
 
 	responseNodes int // 回复节点的个数
 
 	ErrNil = errors.New(RESPONSE_NIL)
 
 	blockTasks map[string](chan string)
+	blockLock  sync.Mutex
 )
 
 //get worker config from ogo
@@ -65,6 +185,12 @@ func (w *OmqWorker) getConfig() {
 	} else {
 		basePort = 7000 //default is 7000
 	}
+	// default storage
+	if st := workerConfig.String("storage"); st != "" {
+		storageType = st
+	} else {
+		storageType = _STORAGE_REDIS // default is redis(有集群用集群, 否则用sentinel)
+	}
 	// local redis addr
 	if raddr := workerConfig.String("redis_addr"); raddr != "" {
 		redisAddr = raddr
@@ -86,6 +212,70 @@ func (w *OmqWorker) getConfig() {
 		redisMTag = mtag
 	}
 
+	// keyring
+	if kr := workerConfig.String("keyring"); kr != "" {
+		keyringPath = kr
+	}
+
+	// read through peers
+	if rp := workerConfig.String("read_peers"); rp != "" {
+		for _, p := range strings.Split(rp, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				readPeers = append(readPeers, p)
+			}
+		}
+	}
+	if rt, err := workerConfig.Int("read_timeout"); err == nil && rt > 0 {
+		readTimeout = rt
+	} else {
+		readTimeout = 500 // default is 500ms
+	}
+
+	// get cache
+	if gc, err := workerConfig.Int("get_cache"); err == nil && gc > 0 {
+		getCacheSize = gc
+	}
+	if gt, err := workerConfig.Int("get_cache_ttl"); err == nil && gt > 0 {
+		getCacheTTL = gt
+	} else {
+		getCacheTTL = 5 // default is 5s
+	}
+
+	// journal & breaker
+	if jp := workerConfig.String("journal_path"); jp != "" {
+		journalPath = jp
+	}
+	if bf, err := workerConfig.Int("breaker_failures"); err == nil && bf > 0 {
+		breakerFailures = bf
+	} else {
+		breakerFailures = 5 // default is 5
+	}
+	if bc, err := workerConfig.Int("breaker_cooldown"); err == nil && bc > 0 {
+		breakerCooldown = bc
+	} else {
+		breakerCooldown = 10 // default is 10s
+	}
+
+	// redis targets, 逗号分隔, 为空不允许指定其他redis
+	redisTargets = make(map[string]bool)
+	if rt := workerConfig.String("redis_targets"); rt != "" {
+		for _, t := range strings.Split(rt, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				redisTargets[t] = true
+			}
+		}
+	}
+	if tp, err := workerConfig.Int("redis_target_pools"); err == nil && tp > 0 {
+		redisTargetPool = tp
+	} else {
+		redisTargetPool = 16 // default is 16
+	}
+	if sp, err := workerConfig.Int("storage_pools"); err == nil && sp > 0 {
+		storagePool = sp
+	} else {
+		storagePool = 64 // default is 64
+	}
+
 	// remote publisher
 	if rp, err := workerConfig.Int("remote_port"); err == nil {
 		remotePort = rp
@@ -95,6 +285,35 @@ func (w *OmqWorker) getConfig() {
 	if pub := workerConfig.String("remote_publisher"); pub != "" {
 		pubAddr = pub
 	}
+	// 多个机房, 逗号分隔, 每个为host:port(对方的base_port, 省略则为remote_port)
+	if rps := workerConfig.String("remote_peers"); rps != "" {
+		for _, p := range strings.Split(rps, ",") {
+			if p = strings.TrimSpace(p); p == "" {
+				continue
+			} else if !strings.Contains(p, ":") {
+				p += ":" + strconv.Itoa(remotePort)
+			}
+			remotePeers = append(remotePeers, p)
+		}
+	} else if pubAddr != "" { //兼容只有一个remote_publisher
+		remotePeers = []string{pubAddr + ":" + strconv.Itoa(remotePort)}
+	}
+	if rr := workerConfig.String("replicate_relay"); rr != "" {
+		replicateRelay, _ = strconv.ParseBool(rr)
+	}
+	if rt := workerConfig.String("replicate_tagged"); rt != "" {
+		replicateTagged, _ = strconv.ParseBool(rt)
+	}
+	if ni := workerConfig.String("node_id"); ni != "" {
+		nodeId = ni
+	}
+
+	// watch
+	if wp, err := workerConfig.Int("watch_port"); err == nil && wp > 0 {
+		watchPort = wp
+	} else {
+		watchPort = basePort + 2 //default is basePort+2
+	}
 
 	if rns, err := workerConfig.Int("responser_nodes"); err == nil {
 		responseNodes = rns
@@ -107,4 +326,118 @@ func (w *OmqWorker) getConfig() {
 	} else {
 		mqBuffer = 1000 // default is 1000
 	}
+
+	// retry policy
+	if rm, err := workerConfig.Int("retry_max"); err == nil {
+		retryMax = rm
+	} else {
+		retryMax = 3 // default is 3
+	}
+	if rb, err := workerConfig.Int("retry_backoff"); err == nil {
+		retryBackoff = rb
+	} else {
+		retryBackoff = 1 // default is 1s
+	}
+	if rbm, err := workerConfig.Int("retry_backoff_max"); err == nil {
+		retryBackoffMax = rbm
+	} else {
+		retryBackoffMax = 300 // default is 300s
+	}
+	if rt, err := workerConfig.Int("retry_timeout"); err == nil {
+		retryTimeout = rt
+	} else {
+		retryTimeout = 0 // default is 0, 不检查超时
+	}
+
+	if fr, err := workerConfig.Int("workflow_retention"); err == nil {
+		flowRetention = fr
+	} else {
+		flowRetention = 3600 // default is 3600s
+	}
+	if tr, err := workerConfig.Int("task_retention"); err == nil {
+		taskRetention = tr
+	} else {
+		taskRetention = 86400 // default is 86400s
+	}
+	if tp := workerConfig.String("track_push"); tp != "" {
+		trackPush, _ = strconv.ParseBool(tp)
+	}
+	if ri := workerConfig.String("reply_task_id"); ri != "" {
+		replyTaskId, _ = strconv.ParseBool(ri)
+	}
+	if sm, err := workerConfig.Int("scatter_max_timeout"); err == nil && sm > 0 {
+		scatterMaxTimeout = sm
+	} else {
+		scatterMaxTimeout = 300 // default is 300s
+	}
+
+	if lt, err := workerConfig.Int("lock_ttl"); err == nil && lt > 0 {
+		lockTTL = lt
+	} else {
+		lockTTL = 30 // default is 30s
+	}
+
+	// sql storage
+	if dsn := workerConfig.String("sql_dsn"); dsn != "" {
+		sqlDSN = dsn
+	}
+	if sp := workerConfig.String("sqlite_path"); sp != "" {
+		sqlitePath = sp
+	} else {
+		sqlitePath = "omq.db" // default is omq.db
+	}
+	if st := workerConfig.String("sql_table"); st != "" {
+		sqlTable = st
+	} else {
+		sqlTable = "omq_kv" // default is omq_kv
+	}
+	// 其他表, 逗号分隔, 为空只能使用默认表
+	sqlTables = make(map[string]bool)
+	if st := workerConfig.String("sql_tables"); st != "" {
+		for _, t := range strings.Split(st, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				sqlTables[t] = true
+			}
+		}
+	}
+	if mo, err := workerConfig.Int("sql_max_open"); err == nil && mo > 0 {
+		sqlMaxOpen = mo
+	} else {
+		sqlMaxOpen = 20 // default is 20
+	}
+	if mi, err := workerConfig.Int("sql_max_idle"); err == nil && mi >= 0 {
+		sqlMaxIdle = mi
+	} else {
+		sqlMaxIdle = 5 // default is 5
+	}
+	if cl, err := workerConfig.Int("sql_conn_lifetime"); err == nil {
+		sqlConnLifetime = cl
+	}
+	if pi, err := workerConfig.Int("sql_purge_interval"); err == nil && pi > 0 {
+		sqlPurgeInterval = pi
+	} else {
+		sqlPurgeInterval = 60 // default is 60s
+	}
+
+	// bolt storage
+	if bp := workerConfig.String("bolt_path"); bp != "" {
+		boltPath = bp
+	} else {
+		boltPath = "omq.bolt" // default is omq.bolt
+	}
+
+	// scheduler
+	if sch := workerConfig.String("scheduler"); sch != "" {
+		scheduler, _ = strconv.ParseBool(sch)
+	}
+	if si, err := workerConfig.Int("scheduler_interval"); err == nil && si > 0 {
+		schedulerInterval = si
+	} else {
+		schedulerInterval = 1 // default is 1s
+	}
+	if st, err := workerConfig.Int("scheduler_lock_ttl"); err == nil && st > 0 {
+		schedulerLockTTL = st
+	} else {
+		schedulerLockTTL = 10 // default is 10s
+	}
 }
@@ -0,0 +1,352 @@
+package workers
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+/*
+ * 存储不可用时的处理(所有存储共用):
+ * 熔断: 连续breaker_failures次连接类错误后, breaker_cooldown秒内不再访问存储, 之后再试
+ * journal: 配置journal_path后, 存储不可用(或journal中还有未重放的内容)时写命令追加到文件
+ * 存储恢复后按顺序重放, 重放完成前新的写命令继续追加, 保证顺序
+ */
+
+const (
+	_BREAKER_CLOSED = "ok"
+	_BREAKER_OPEN   = "open"
+
+	_JOURNAL_INTERVAL = time.Second //检查是否需要重放的间隔
+	_JOURNAL_BATCH    = 100         //每批重放的命令数, 批之间释放锁
+	_JOURNAL_OFFSET   = ".offset"   //journal文件名后接, 已重放到的位置
+)
+
+var ErrUnavailable = errors.New("storage unavailable")
+
+type storageBreaker struct {
+	lock      sync.Mutex
+	failures  int
+	openUntil time.Time
+	lastErr   string
+}
+
+type writeJournal struct {
+	lock    sync.Mutex
+	pending int64 //未重放的命令数(原子操作, 读取时不需要锁)
+	offset  int64 //已重放到的位置(文件只追加, 全部重放完之后删除)
+}
+
+var (
+	breaker = &storageBreaker{}
+	journal = &writeJournal{}
+)
+
+/* {{{ func unavailable(err error) bool
+ * 是否是连接类错误(其他错误说明存储是可用的)
+ */
+func unavailable(err error) bool {
+	if err == nil {
+		return false
+	}
+	var ne net.Error
+	if errors.As(err, &ne) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, ErrUnavailable) {
+		return true
+	}
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"connection refused", "connection reset", "broken pipe", "i/o timeout", "dial tcp", "no such host", "pool timeout", "use of closed"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+/* }}} */
+
+/* {{{ func (b *storageBreaker) isOpen() bool
+ *
+ */
+func (b *storageBreaker) isOpen() bool {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	return time.Now().Before(b.openUntil)
+}
+
+/* }}} */
+
+/* {{{ func (b *storageBreaker) record(w *OmqWorker, err error)
+ * 记录存储访问结果, 连续失败达到次数则熔断
+ */
+func (b *storageBreaker) record(w *OmqWorker, err error) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if !unavailable(err) {
+		if b.failures >= breakerFailures {
+			w.Info("localstorage recovered")
+		}
+		b.failures = 0
+		return
+	}
+	b.failures++
+	b.lastErr = err.Error()
+	if b.failures >= breakerFailures {
+		b.openUntil = time.Now().Add(time.Duration(breakerCooldown) * time.Second)
+		w.Error("localstorage unavailable(%d failures), retry in %ds: %s", b.failures, breakerCooldown, err)
+	}
+}
+
+/* }}} */
+
+/* {{{ func (b *storageBreaker) state() (state string, failures int, lastErr string)
+ *
+ */
+func (b *storageBreaker) state() (string, int, string) {
+	b.lock.Lock()
+	defer b.lock.Unlock()
+	if time.Now().Before(b.openUntil) {
+		return _BREAKER_OPEN, b.failures, b.lastErr
+	}
+	return _BREAKER_CLOSED, b.failures, b.lastErr
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) localStorage(cmd []string) ([]string, error)
+ * 写命令, 存储不可用时写入journal(配置了的话)
+ * 返回编码后的命令(复制到其他机房用), journal中保存的也是编码后的
+ */
+func (w *OmqWorker) localStorage(cmd []string) (rep []string, err error) {
+	if rep, err = w.encodeCommand(cmd); err != nil {
+		return nil, err
+	}
+	if journalPath != "" {
+		journal.lock.Lock()
+		if atomic.LoadInt64(&journal.pending) > 0 || breaker.isOpen() { //排在未重放的命令之后
+			err = w.journalAppend(rep)
+			journal.lock.Unlock()
+			return
+		}
+		journal.lock.Unlock()
+	} else if breaker.isOpen() {
+		return rep, ErrUnavailable
+	}
+
+	err = w.storeLocal(rep)
+	breaker.record(w, err)
+	if journalPath != "" && unavailable(err) {
+		journal.lock.Lock()
+		defer journal.lock.Unlock()
+		w.Debug("localstorage unavailable, journal: %q", rep)
+		return rep, w.journalAppend(rep)
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) journalAppend(cmd []string) error
+ * 写入journal, 同时使GET缓存失效(不等到重放), 调用者持有锁
+ */
+func (w *OmqWorker) journalAppend(cmd []string) error {
+	if len(cmd) >= 3 {
+		gcache.invalidate(strings.ToUpper(cmd[0]), w.newLocalStorage(cmd))
+	}
+	return journal.append(cmd)
+}
+
+/* }}} */
+
+/* {{{ func (j *writeJournal) append(cmd []string) error
+ * 每行一个命令(json, 每帧base64, 编码后的值不是合法utf8), 调用者持有锁
+ */
+func (j *writeJournal) append(cmd []string) error {
+	frames := make([][]byte, len(cmd))
+	for i, c := range cmd {
+		frames[i] = []byte(c)
+	}
+	line, err := json.Marshal(frames)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(journalPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if _, err = f.Write(append(line, '\n')); err != nil {
+		return err
+	}
+	atomic.AddInt64(&j.pending, 1)
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) openJournal()
+ * 启动时统计上次未重放的命令, 并开始检查重放
+ */
+func (w *OmqWorker) openJournal() {
+	if journalPath == "" {
+		return
+	}
+	if b, err := ioutil.ReadFile(journalPath + _JOURNAL_OFFSET); err == nil {
+		journal.offset, _ = strconv.ParseInt(strings.TrimSpace(string(b)), 10, 64)
+	}
+	if cmds, _, err := readJournal(journal.offset, 0); err != nil {
+		w.Error("read journal failed: %s", err)
+	} else if len(cmds) > 0 {
+		w.Info("journal has %d commands to replay", len(cmds))
+		atomic.StoreInt64(&journal.pending, int64(len(cmds)))
+	}
+	go w.replayJournal()
+}
+
+/* }}} */
+
+/* {{{ func readJournal(offset int64, max int) (cmds [][]string, ends []int64, err error)
+ * 从offset开始读取最多max个命令(max<=0不限制), ends为每个命令之后的位置
+ * 损坏的行返回nil命令, 同样计数, 重放时跳过
+ */
+func readJournal(offset int64, max int) (cmds [][]string, ends []int64, err error) {
+	f, err := os.Open(journalPath)
+	if os.IsNotExist(err) {
+		return nil, nil, nil
+	} else if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		return nil, nil, err
+	}
+	reader := bufio.NewReader(f)
+	for max <= 0 || len(cmds) < max {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' { //不完整的最后一行(正在写入)不读取
+			offset += int64(len(line))
+			var frames [][]byte
+			var cmd []string
+			if json.Unmarshal(line, &frames) == nil {
+				cmd = make([]string, len(frames))
+				for i, f := range frames {
+					cmd[i] = string(f)
+				}
+			}
+			cmds = append(cmds, cmd)
+			ends = append(ends, offset)
+		}
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, nil, err
+		}
+	}
+	return cmds, ends, nil
+}
+
+/* }}} */
+
+/* {{{ func (j *writeJournal) advance(done int, offset int64) error
+ * 记录重放进度, 全部重放完删除journal, 调用者持有锁
+ */
+func (j *writeJournal) advance(done int, offset int64) error {
+	j.offset = offset
+	if atomic.AddInt64(&j.pending, -int64(done)) > 0 {
+		tmp := journalPath + _JOURNAL_OFFSET + ".tmp"
+		if err := ioutil.WriteFile(tmp, []byte(strconv.FormatInt(offset, 10)), 0600); err != nil {
+			return err
+		}
+		return os.Rename(tmp, journalPath+_JOURNAL_OFFSET)
+	}
+	atomic.StoreInt64(&j.pending, 0)
+	j.offset = 0
+	for _, f := range []string{journalPath, journalPath + _JOURNAL_OFFSET} {
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) replayJournal()
+ * 存储恢复(熔断结束)后按顺序分批重放, 重放期间新的写命令继续追加到journal
+ */
+func (w *OmqWorker) replayJournal() {
+	for range time.Tick(_JOURNAL_INTERVAL) {
+		for !breaker.isOpen() && atomic.LoadInt64(&journal.pending) > 0 {
+			if !w.replay() {
+				break
+			}
+		}
+	}
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) replay() bool
+ * 重放一批, 只有读取以及记录进度时持有锁(只有一个goroutine重放, 新命令只会追加在后面)
+ * 返回是否可以继续下一批
+ */
+func (w *OmqWorker) replay() bool {
+	journal.lock.Lock()
+	cmds, ends, err := readJournal(journal.offset, _JOURNAL_BATCH)
+	journal.lock.Unlock()
+	if err != nil {
+		w.Error("read journal failed: %s", err)
+		return false
+	} else if len(cmds) == 0 {
+		return false
+	}
+	done := 0
+	for i, cmd := range cmds {
+		if cmd == nil {
+			w.Error("journal corrupted before offset %d, skipped", ends[i])
+			done++
+			continue
+		}
+		err := w.storeLocal(cmd)
+		breaker.record(w, err)
+		if unavailable(err) { //又不可用了, 剩下的下次再重放
+			break
+		} else if err != nil {
+			w.Info("replay %q failed: %s", cmd, err)
+		}
+		done++
+	}
+	if done == 0 {
+		return false
+	}
+	journal.lock.Lock()
+	err = journal.advance(done, ends[done-1])
+	left := atomic.LoadInt64(&journal.pending)
+	journal.lock.Unlock()
+	if err != nil {
+		w.Error("save journal offset failed: %s", err)
+	}
+	w.Info("journal replayed %d commands, %d left", done, left)
+	return done == len(cmds)
+}
+
+/* }}} */
+
+/* {{{ func health() []string
+ * 存储状态, 连续失败次数, journal中待重放的命令数, 最后的错误
+ */
+func health() []string {
+	state, failures, lastErr := breaker.state()
+	pending := atomic.LoadInt64(&journal.pending)
+	return []string{state, strconv.Itoa(failures), strconv.FormatInt(pending, 10), lastErr}
+}
+
+/* }}} */
@@ -0,0 +1,126 @@
+package workers
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestJournalReplayOrder(t *testing.T) {
+	w := newTestWorker(t)
+	journalPath = filepath.Join(t.TempDir(), "journal")
+	const n = _JOURNAL_BATCH*2 + 50
+
+	// 存储不可用, 写命令按顺序追加
+	breaker.openUntil = time.Now().Add(time.Minute)
+	for i := 1; i <= n; i++ {
+		if _, err := w.localStorage([]string{COMMAND_SET, "", "k", strconv.Itoa(i)}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.localStorage([]string{COMMAND_INCR, "", "c", "1"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := w.localStorage([]string{COMMAND_DEL, "", "k"}); err != nil {
+		t.Fatal(err)
+	}
+	if p := atomic.LoadInt64(&journal.pending); p != 2*n+1 {
+		t.Fatalf("pending %d", p)
+	}
+	breaker.openUntil = time.Time{}
+
+	// 每批之后记录进度
+	if !w.replay() {
+		t.Fatal("first batch stopped")
+	}
+	b, err := ioutil.ReadFile(journalPath + _JOURNAL_OFFSET)
+	if err != nil || string(b) != strconv.FormatInt(journal.offset, 10) {
+		t.Fatalf("offset file %q, %v", b, err)
+	}
+	if p := atomic.LoadInt64(&journal.pending); p != 2*n+1-_JOURNAL_BATCH {
+		t.Fatalf("pending after batch %d", p)
+	}
+
+	// 重放期间的新命令排在后面
+	if _, err := w.localStorage([]string{COMMAND_SET, "", "k", "last"}); err != nil {
+		t.Fatal(err)
+	}
+	for w.replay() {
+	}
+	if p := atomic.LoadInt64(&journal.pending); p != 0 {
+		t.Fatalf("pending after replay %d", p)
+	}
+	if _, err := os.Stat(journalPath); !os.IsNotExist(err) {
+		t.Fatalf("journal not removed: %v", err)
+	}
+	if v := mustGet(t, w, "k"); v != "last" {
+		t.Fatalf("k = %q, want last", v)
+	}
+	r, err := w.localGet([]string{COMMAND_INCR, "", "c", "0"})
+	if err != nil || r[0] != strconv.Itoa(n) {
+		t.Fatalf("c = %q, %v", r, err)
+	}
+}
+
+func TestJournalEncoded(t *testing.T) {
+	setTestKeyring(t)
+	w := newTestWorker(t)
+	journalPath = filepath.Join(t.TempDir(), "journal")
+	breaker.openUntil = time.Now().Add(time.Minute)
+	if _, err := w.localStorage([]string{COMMAND_SET, `{"Encrypt":"k1"}`, "k", "secret"}); err != nil {
+		t.Fatal(err)
+	}
+	cmds, _, err := readJournal(0, 0)
+	if err != nil || len(cmds) != 1 {
+		t.Fatalf("journal %q, %v", cmds, err)
+	}
+	if !isEncoded(cmds[0][3]) {
+		t.Fatal("journal written in plaintext")
+	}
+	breaker.openUntil = time.Time{}
+	w.replay()
+	if v := mustGet(t, w, "k"); v != "secret" {
+		t.Fatalf("get %q", v)
+	}
+}
+
+func TestJournalCorrupted(t *testing.T) {
+	w := newTestWorker(t)
+	journalPath = filepath.Join(t.TempDir(), "journal")
+	breaker.openUntil = time.Now().Add(time.Minute)
+	appendLine := func(line string) {
+		f, err := os.OpenFile(journalPath, os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString(line)
+		f.Close()
+		atomic.AddInt64(&journal.pending, 1)
+	}
+	if _, err := w.localStorage([]string{COMMAND_SET, "", "a", "1"}); err != nil {
+		t.Fatal(err)
+	}
+	appendLine("not json\n")
+	if _, err := w.localStorage([]string{COMMAND_SET, "", "b", "2"}); err != nil {
+		t.Fatal(err)
+	}
+	appendLine("[\"broken\n")
+	breaker.openUntil = time.Time{}
+
+	// 损坏的行跳过并计入进度, 最后一行损坏也不会一直pending
+	for w.replay() {
+	}
+	if p := atomic.LoadInt64(&journal.pending); p != 0 {
+		t.Fatalf("pending after replay %d", p)
+	}
+	if v := mustGet(t, w, "a"); v != "1" {
+		t.Fatalf("a = %q", v)
+	}
+	if v := mustGet(t, w, "b"); v != "2" {
+		t.Fatalf("b = %q", v)
+	}
+}
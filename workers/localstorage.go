@@ -3,17 +3,20 @@ package workers
 import (
 	"encoding/json"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
-
-	"gopkg.in/redis.v3"
 )
 
 const (
 	_STORAGE_REDIS  = "redis"
 	_STORAGE_MYSQL  = "mysql"
 	_STORAGE_ORACLE = "oracle"
+	_STORAGE_SQLITE = "sqlite3"
+
+	_TIMING_BATCH       = 10          //TIMING默认每次领取的数量
+	_TIMING_RETRY_DELAY = time.Minute //领取后无法处理的内容放回后多久再领取
 )
 
 type StorageOption struct {
@@ -23,6 +26,12 @@ type StorageOption struct {
 	Host  string
 	Port  string
 	Pwd   string
+	Queue string //SCHEDULE/RECUR: 到期后由omq放入的队列
+
+	Compress string //SET/SCHEDULE: 值的压缩方式, gzip或snappy
+	Encrypt  string //SET/SCHEDULE: 加密密钥id(keyring中)
+
+	ReadThrough bool //GET: 本地没有时查询其他机房
 }
 
 type LocalStorage struct {
@@ -31,42 +40,68 @@ type LocalStorage struct {
 	value  string
 	expire int
 	ts     int
+	spec   string // 周期定时的规则
+	tz     string // 周期定时的时区
+
+	batch      int  // TIMING每次领取的数量
+	withScores bool // TIMING是否返回定时时间戳
+
+	min string // SCHEDULES的时间范围
+	max string
+
+	args []string // MGET/MSET等多个key的命令, key之后的所有参数
 }
 
-/* {{{ func (w *OmqWorker) localStorage(cmd []string) error
- * 处理SET/DEL命令
+/* {{{ func (w *OmqWorker) newLocalStorage(cmd []string) *LocalStorage
+ * 解析命令: act, option, key, value, ...(至少3帧)
  */
-func (w *OmqWorker) localStorage(cmd []string) error { //set + del
-
-	if cc == nil && Redis == nil { //没有本地存储
-		return fmt.Errorf("can't reach localstorage")
+func (w *OmqWorker) newLocalStorage(cmd []string) *LocalStorage {
+	ls := new(LocalStorage)
+	option := cmd[1]
+	ls.key = cmd[2]
+	if option == "" {
+		ls.option = &StorageOption{} //默认存储
+	} else if strings.ToLower(option) == "redis" {
+		//兼容旧版, 新版应该传入一个json,或者为空
+		ls.option = &StorageOption{Type: _STORAGE_REDIS}
 	} else {
-		w.Trace("save local storage: %q", cmd)
+		//解析
+		o := new(StorageOption)
+		if err := json.Unmarshal([]byte(option), o); err != nil {
+			w.Info("unmarshal option failed: %s", option)
+			ls.option = &StorageOption{} //默认
+		} else {
+			ls.option = o
+		}
 	}
+	if len(cmd) >= 4 {
+		ls.value = cmd[3]
+	}
+	ls.args = cmd[3:]
+	return ls
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) storeLocal(cmd []string) error
+ * 处理SET/DEL命令, 不经过journal
+ */
+func (w *OmqWorker) storeLocal(cmd []string) (err error) { //set + del
+
+	w.Trace("save local storage: %q", cmd)
 
 	// 解析命令
 	if len(cmd) >= 3 {
 		act := strings.ToUpper(cmd[0])
-		ls := new(LocalStorage)
-		option := cmd[1]
-		ls.key = cmd[2]
-		if option == "" || strings.ToLower(option) == "redis" {
-			//兼容旧版, 新版应该传入一个json,或者为空
-			ls.option = &StorageOption{Type: _STORAGE_REDIS}
-		} else {
-			//解析
-			o := new(StorageOption)
-			if err := json.Unmarshal([]byte(option), o); err != nil {
-				w.Info("unmarshal option failed: %s", option)
-				ls.option = &StorageOption{Type: _STORAGE_REDIS} //默认
-			} else {
-				ls.option = o
-			}
-		}
-		if len(cmd) >= 4 {
-			ls.value = cmd[3]
-		}
+		ls := w.newLocalStorage(cmd)
 		w.Trace("[act: %s][key: %s][value: %s]", act, ls.key, ls.value)
+		defer func() {
+			// 失败也可能改了一部分, 缓存都失效
+			gcache.invalidate(act, ls)
+			if err == nil { //修改成功, 通知watch
+				w.notifyWatch(act, ls)
+			}
+		}()
 		switch act {
 		case COMMAND_SET:
 			if len(cmd) >= 5 {
@@ -76,11 +111,51 @@ func (w *OmqWorker) localStorage(cmd []string) error { //set + del
 			return ls.Set()
 		case COMMAND_DEL:
 			return ls.Del()
+		case COMMAND_EXPIRE:
+			ls.expire, _ = strconv.Atoi(ls.value)
+			return ls.Expire()
+		case COMMAND_MSET:
+			return ls.MSet()
+		case COMMAND_INCR: //来自其他机房的计数
+			var r []string
+			if r, err = ls.Incr(); err == nil {
+				ls.args = r //watch通知增加后的值, 与本地INCR一致
+			}
+			return
+		case COMMAND_HSET:
+			return ls.HSet()
+		case COMMAND_HDEL:
+			return ls.HDel()
+		case COMMAND_SADD:
+			return ls.SAdd()
+		case COMMAND_SREM:
+			return ls.SRem()
 		case COMMAND_SCHEDULE:
 			if len(cmd) >= 5 {
 				ls.ts, _ = strconv.Atoi(cmd[4])
 			}
 			return ls.Schedule()
+		case COMMAND_UNSCHEDULE:
+			return ls.Unschedule()
+		case COMMAND_RESCHEDULE:
+			if len(cmd) >= 5 {
+				ls.ts, _ = strconv.Atoi(cmd[4])
+			}
+			return ls.Reschedule()
+		case COMMAND_RECUR:
+			if len(cmd) >= 5 {
+				ls.spec = cmd[4]
+			}
+			if len(cmd) >= 6 {
+				ls.tz = cmd[5]
+			}
+			return ls.Recur()
+		case COMMAND_RECURDEL:
+			return ls.Unrecur()
+		case COMMAND_RECURPAUSE:
+			return ls.PauseRecur(true)
+		case COMMAND_RECURRESUME:
+			return ls.PauseRecur(false)
 		default:
 			return fmt.Errorf("action error: %s", act)
 		}
@@ -98,38 +173,90 @@ func (w *OmqWorker) localStorage(cmd []string) error { //set + del
  */
 func (w *OmqWorker) localGet(cmd []string) (r []string, err error) { //set + del
 
-	if cc == nil && Redis == nil { //没有本地存储
-		err = fmt.Errorf("can't reach localstorage")
-		return
-	} else {
-		w.Trace("save local storage: %q", cmd)
+	w.Trace("get local storage: %q", cmd)
+
+	// 熔断时不访问存储
+	if breaker.isOpen() {
+		return nil, ErrUnavailable
 	}
+	defer func() {
+		breaker.record(w, err)
+	}()
 
 	// 解析命令
 	if len(cmd) >= 3 {
 		act := strings.ToUpper(cmd[0])
-		ls := new(LocalStorage)
-		option := cmd[1]
-		ls.key = cmd[2]
-		if option == "" || strings.ToLower(option) == "redis" {
-			//兼容旧版, 新版应该传入一个json,或者为空
-			ls.option = &StorageOption{Type: _STORAGE_REDIS}
-		} else {
-			//解析
-			o := new(StorageOption)
-			if err := json.Unmarshal([]byte(option), o); err != nil {
-				w.Info("unmarshal option failed: %s", option)
-				ls.option = &StorageOption{Type: _STORAGE_REDIS} //默认
-			} else {
-				ls.option = o
-			}
-		}
+		ls := w.newLocalStorage(cmd)
 		w.Trace("[act: %s][key: %s]", act, ls.key)
 		switch act {
 		case COMMAND_GET:
-			return ls.Get()
+			v, gen, ok := gcache.get(ls)
+			if ok {
+				return []string{v}, nil
+			}
+			if r, err = ls.Get(); err == nil {
+				if getCacheSize > 0 { //缓存时间不超过key的剩余TTL
+					if t, e := ls.TTL(); e == nil {
+						ttl, _ := strconv.ParseInt(t[0], 10, 64)
+						gcache.put(ls, r[0], ttl, gen)
+					}
+				}
+			} else if err == ErrNil && ls.option.ReadThrough && len(readPeers) > 0 {
+				return ls.readThrough()
+			}
+			return
+		case COMMAND_EXISTS:
+			return ls.Exists()
+		case COMMAND_TTL:
+			return ls.TTL()
+		case COMMAND_INCR:
+			defer gcache.invalidate(act, ls)
+			return ls.Incr()
+		case COMMAND_MGET:
+			return ls.MGet()
+		case COMMAND_SCAN:
+			return ls.Scan()
+		case COMMAND_GETV:
+			return ls.GetV()
+		case COMMAND_CAS:
+			defer gcache.invalidate(act, ls)
+			return ls.CAS()
+		case COMMAND_LOCK:
+			return ls.Lock()
+		case COMMAND_RENEW:
+			return ls.Renew()
+		case COMMAND_UNLOCK:
+			return nil, ls.Unlock()
+		case COMMAND_HGET:
+			return ls.HGet()
+		case COMMAND_HGETALL:
+			return ls.HGetAll()
+		case COMMAND_SMEMBERS:
+			return ls.SMembers()
+		case COMMAND_SISMEMBER:
+			return ls.SIsMember()
 		case COMMAND_TIMING:
+			if len(cmd) >= 4 {
+				ls.batch, _ = strconv.Atoi(cmd[3])
+			}
+			if len(cmd) >= 5 {
+				ls.withScores = strings.ToUpper(cmd[4]) == "WITHSCORES" || cmd[4] == "1"
+			}
 			return ls.Timing()
+		case COMMAND_RECURLIST:
+			return ls.Recurs()
+		case COMMAND_SCHEDULES:
+			ls.min, ls.max = "-inf", "+inf"
+			if len(cmd) >= 4 && cmd[3] != "" {
+				ls.min = cmd[3]
+			}
+			if len(cmd) >= 5 && cmd[4] != "" {
+				ls.max = cmd[4]
+			}
+			if len(cmd) >= 6 {
+				ls.batch, _ = strconv.Atoi(cmd[5])
+			}
+			return ls.Schedules()
 		default:
 			return nil, fmt.Errorf("action error: %s", act)
 		}
@@ -143,21 +270,17 @@ func (w *OmqWorker) localGet(cmd []string) (r []string, err error) { //set + del
 /* }}} */
 
 /* {{{ func (ls *LocalStorage) Set() (err error)
- *
+ * 同时增加版本号, 否则CAS VERSION会用旧版本号覆盖
  */
 func (ls *LocalStorage) Set() (err error) {
-	if cc != nil { // use cluster
-		err = cc.Set(ls.key, ls.value, time.Duration(ls.expire)*time.Second).Err()
-	} else {
-		redisConn := Redis.Pool.Get()
-		defer redisConn.Close()
-		if ls.expire > 0 {
-			_, err = redisConn.Do("SET", ls.key, ls.value, "EX", ls.expire)
-		} else {
-			_, err = redisConn.Do("SET", ls.key, ls.value)
-		}
+	if ls.value, err = encodeValue(ls.option, ls.value, false); err != nil {
+		return
 	}
-	return
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	return s.Set(ls.key, ls.value, ls.expire)
 }
 
 /* }}} */
@@ -166,27 +289,14 @@ func (ls *LocalStorage) Set() (err error) {
  *
  */
 func (ls *LocalStorage) Get() (r []string, err error) {
-	if cc != nil { // use cluster
-		var rs string
-		if rs, err = cc.Get(ls.key).Result(); err != nil {
-			return
-		} else {
-			r = []string{rs}
-		}
-	} else {
-		redisConn := Redis.Pool.Get()
-		defer redisConn.Close()
-		if result, e := redisConn.Do("GET", ls.key); e == nil {
-			if result == nil {
-				return nil, ErrNil
-			} else {
-				if len(result.([]byte)) == 0 {
-					return nil, ErrNil
-				}
-				r = []string{string(result.([]byte))}
-			}
-		} else {
-			err = e
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	var v string
+	if v, err = s.Get(ls.key); err == nil {
+		if v, err = decodeValue(v); err == nil {
+			r = []string{v}
 		}
 	}
 	return
@@ -195,76 +305,80 @@ func (ls *LocalStorage) Get() (r []string, err error) {
 /* }}} */
 
 /* {{{ func (ls *LocalStorage) Timing() (r []string, err error)
- *
+ * 领取到期的定时内容, withScores时每项2帧: value, 定时时间戳
  */
 func (ls *LocalStorage) Timing() (r []string, err error) {
-	now := time.Now().Unix() //当前的时间戳
-	if cc != nil {           // use cluster
-		if rz, e := cc.ZRangeByScoreWithScores(ls.key, redis.ZRangeByScore{Min: "0", Max: strconv.Itoa(int(now))}).Result(); e != nil {
-			err = e
-		} else {
-			r = make([]string, 0)
-			for _, rm := range rz {
-				r = append(r, rm.Member.(string))
-				cc.ZRem(ls.key, rm.Member.(string))
-			}
+	var members, scores []string
+	if members, scores, err = ls.claim(); err != nil {
+		return
+	} else if len(members) == 0 {
+		return nil, ErrNil
+	}
+	// 已经从存储中取出, 解码失败(keyring缺失或内容损坏)的放回, 稍后再试, 不能丢失也不能挡住后面的
+	decoded, dscores := make([]string, 0, len(members)), make([]string, 0, len(members))
+	for i, m := range members {
+		d, e := decodeValue(m)
+		if e != nil {
+			ls.putBack(m, int(time.Now().Add(_TIMING_RETRY_DELAY).Unix()))
+			continue
 		}
-	} else {
-		redisConn := Redis.Pool.Get()
-		defer redisConn.Close()
-		//if result, e := redisConn.Do("ZRANGEBYSCORE", ls.key, 0, now, "WITHSCORES", "LIMIT", 0, 10); e == nil {
-		if result, e := redisConn.Do("ZRANGEBYSCORE", ls.key, 0, now, "LIMIT", 0, 10); e == nil {
-			if result == nil {
-				return nil, ErrNil
-			} else {
-				switch rt := result.(type) {
-				case []byte:
-					if len(rt) == 0 {
-						return nil, ErrNil
-					}
-					r = []string{string(rt)}
-				case []interface{}:
-					if len(rt) == 0 {
-						return nil, ErrNil
-					}
-					//zrem := make([]string, 0)
-					r = make([]string, 0)
-					for k, rtt := range rt {
-						v := string(rtt.([]byte))
-						r = append(r, v)
-						if k%2 == 0 { //偶数为member(0-based)
-							//fmt.Printf("k:%d,v:%s\n", k, v)
-							//zrem = append(zrem, v)
-							redisConn.Do("ZREM", ls.key, v)
-						}
-					}
-					//redisConn.Do("ZREM", ls.key, strings.Join(zrem, " "))
-					//redisConn.Do("ZREM", ls.key, zrem)
-				default:
-					err = fmt.Errorf("unknown type")
-				}
-			}
-		} else {
-			err = e
+		decoded, dscores = append(decoded, d), append(dscores, scores[i])
+	}
+	if len(decoded) == 0 {
+		return nil, ErrNil
+	}
+	members, scores = decoded, dscores
+	ls.rearm(members) //周期定时, 计算下一次
+	if ls.withScores {
+		r = make([]string, 0, len(members)*2)
+		for i, m := range members {
+			r = append(r, m, scores[i])
 		}
+	} else {
+		r = members
 	}
 	return
 }
 
 /* }}} */
 
+/* {{{ func (ls *LocalStorage) putBack(member string, ts int)
+ * 领取之后无法处理的内容放回定时集合(member为存储中的内容, 已编码)
+ */
+func (ls *LocalStorage) putBack(member string, ts int) {
+	if s, err := ls.storage(); err == nil {
+		s.Schedule(ls.key, member, ts)
+	}
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) claim() (members, scores []string, err error)
+ * 取出并删除到期内容, 多个omq同时领取时每项只被领取一次
+ */
+func (ls *LocalStorage) claim() (members, scores []string, err error) {
+	batch := ls.batch
+	if batch <= 0 {
+		batch = _TIMING_BATCH
+	}
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	return s.Claim(ls.key, time.Now().Unix(), batch)
+}
+
+/* }}} */
+
 /* {{{ func (ls *LocalStorage) Del() error
  *
  */
 func (ls *LocalStorage) Del() (err error) {
-	if cc != nil { // use cluster
-		err = cc.Del(ls.key).Err()
-	} else {
-		redisConn := Redis.Pool.Get()
-		defer redisConn.Close()
-		_, err = redisConn.Do("DEL", ls.key)
+	var s Storage
+	if s, err = ls.storage(); err == nil {
+		err = s.Del(ls.key)
 	}
-	return err
+	return
 }
 
 /* }}} */
@@ -273,12 +387,329 @@ func (ls *LocalStorage) Del() (err error) {
  *
  */
 func (ls *LocalStorage) Schedule() (err error) {
-	if cc != nil { // use cluster
-		err = cc.ZAdd(ls.key, redis.Z{float64(ls.ts), ls.value}).Err()
-	} else {
-		redisConn := Redis.Pool.Get()
-		defer redisConn.Close()
-		_, err = redisConn.Do("ZADD", ls.key, ls.ts, ls.value)
+	if ls.value, err = encodeValue(ls.option, ls.value, true); err != nil {
+		return
+	}
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	if err = s.Schedule(ls.key, ls.value, ls.ts); err == nil {
+		err = ls.register()
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) Unschedule() error
+ * 取消定时
+ */
+func (ls *LocalStorage) Unschedule() (err error) {
+	if ls.value, err = encodeValue(ls.option, ls.value, true); err != nil { //与SCHEDULE编码一致才能找到
+		return
+	}
+	var s Storage
+	if s, err = ls.storage(); err == nil {
+		err = s.Unschedule(ls.key, ls.value)
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) Reschedule() error
+ * 修改定时时间, 定时不存在返回ErrNil
+ */
+func (ls *LocalStorage) Reschedule() (err error) {
+	if ls.value, err = encodeValue(ls.option, ls.value, true); err != nil {
+		return
+	}
+	var s Storage
+	if s, err = ls.storage(); err == nil {
+		err = s.Reschedule(ls.key, ls.value, ls.ts)
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) Schedules() (r []string, err error)
+ * 列出时间范围内的定时(不领取), 每项2帧: value, 定时时间戳
+ */
+func (ls *LocalStorage) Schedules() (r []string, err error) {
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	var members, scores []string
+	if members, scores, err = s.Schedules(ls.key, ls.min, ls.max, ls.batch); err != nil {
+		return
+	} else if len(members) == 0 {
+		return nil, ErrNil
+	}
+	if members, err = decodeValues(members); err != nil {
+		return
+	}
+	r = make([]string, 0, len(members)*2)
+	for i, m := range members {
+		r = append(r, m, scores[i])
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) Exists() (r []string, err error)
+ * 存在返回"1", 否则返回"0"
+ */
+func (ls *LocalStorage) Exists() (r []string, err error) {
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	var ok bool
+	if ok, err = s.Exists(ls.key); err == nil {
+		if ok {
+			r = []string{"1"}
+		} else {
+			r = []string{"0"}
+		}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) TTL() (r []string, err error)
+ * 剩余秒数, 没有过期时间返回"-1", key不存在返回ErrNil
+ */
+func (ls *LocalStorage) TTL() (r []string, err error) {
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	var ttl int64
+	if ttl, err = s.TTL(ls.key); err == nil {
+		r = []string{strconv.FormatInt(ttl, 10)}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) Expire() (err error)
+ * 设置过期时间, expire<=0则去掉过期时间, key不存在返回ErrNil
+ */
+func (ls *LocalStorage) Expire() (err error) {
+	var s Storage
+	if s, err = ls.storage(); err == nil {
+		err = s.Expire(ls.key, ls.expire)
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) Incr() (r []string, err error)
+ * 计数器, value为增量(默认1), 返回新值
+ */
+func (ls *LocalStorage) Incr() (r []string, err error) {
+	delta := int64(1)
+	if ls.value != "" {
+		if delta, err = strconv.ParseInt(ls.value, 10, 64); err != nil {
+			return nil, fmt.Errorf("delta error: %s", ls.value)
+		}
+	}
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	var n int64
+	if n, err = s.Incr(ls.key, delta); err == nil {
+		r = []string{strconv.FormatInt(n, 10)}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) MGet() (r []string, err error)
+ * 获取多个key, 每个key一帧, 不存在的为空
+ */
+func (ls *LocalStorage) MGet() (r []string, err error) {
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	if r, err = s.MGet(append([]string{ls.key}, ls.args...)...); err != nil {
+		return
+	}
+	return decodeValues(r)
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) MSet() (err error)
+ * 设置多个key, 参数为key,value成对
+ */
+func (ls *LocalStorage) MSet() (err error) {
+	pairs := append([]string{ls.key}, ls.args...)
+	if len(pairs)%2 != 0 {
+		return fmt.Errorf("mset need key,value pairs")
+	}
+	var s Storage
+	if s, err = ls.storage(); err == nil {
+		err = s.MSet(pairs...)
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) HGet() (r []string, err error)
+ * value为field
+ */
+func (ls *LocalStorage) HGet() (r []string, err error) {
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	var v string
+	if v, err = s.HGet(ls.key, ls.value); err == nil {
+		r = []string{v}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) HSet() error
+ * 参数为field, value
+ */
+func (ls *LocalStorage) HSet() (err error) {
+	if len(ls.args) < 2 {
+		return fmt.Errorf("hset need field and value")
+	}
+	var s Storage
+	if s, err = ls.storage(); err == nil {
+		err = s.HSet(ls.key, ls.args[0], ls.args[1])
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) HDel() error
+ * 参数为一个或多个field
+ */
+func (ls *LocalStorage) HDel() (err error) {
+	if len(ls.args) == 0 {
+		return fmt.Errorf("hdel need field")
+	}
+	var s Storage
+	if s, err = ls.storage(); err == nil {
+		err = s.HDel(ls.key, ls.args...)
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) HGetAll() (r []string, err error)
+ * 每项2帧: field, value
+ */
+func (ls *LocalStorage) HGetAll() (r []string, err error) {
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	var all map[string]string
+	if all, err = s.HGetAll(ls.key); err != nil {
+		return
+	} else if len(all) == 0 {
+		return nil, ErrNil
+	}
+	fields := make([]string, 0, len(all))
+	for f := range all {
+		fields = append(fields, f)
+	}
+	sort.Strings(fields)
+	r = make([]string, 0, len(all)*2)
+	for _, f := range fields {
+		r = append(r, f, all[f])
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) SAdd() error
+ * 参数为一个或多个member
+ */
+func (ls *LocalStorage) SAdd() (err error) {
+	if len(ls.args) == 0 {
+		return fmt.Errorf("sadd need member")
+	}
+	var s Storage
+	if s, err = ls.storage(); err == nil {
+		err = s.SAdd(ls.key, ls.args...)
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) SRem() error
+ * 参数为一个或多个member
+ */
+func (ls *LocalStorage) SRem() (err error) {
+	if len(ls.args) == 0 {
+		return fmt.Errorf("srem need member")
+	}
+	var s Storage
+	if s, err = ls.storage(); err == nil {
+		err = s.SRem(ls.key, ls.args...)
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) SMembers() (r []string, err error)
+ *
+ */
+func (ls *LocalStorage) SMembers() (r []string, err error) {
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	if r, err = s.SMembers(ls.key); err == nil {
+		if len(r) == 0 {
+			return nil, ErrNil
+		}
+		sort.Strings(r)
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) SIsMember() (r []string, err error)
+ * value为member, 是返回"1", 否则返回"0"
+ */
+func (ls *LocalStorage) SIsMember() (r []string, err error) {
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	var ok bool
+	if ok, err = s.SIsMember(ls.key, ls.value); err == nil {
+		if ok {
+			r = []string{"1"}
+		} else {
+			r = []string{"0"}
+		}
 	}
 	return
 }
@@ -0,0 +1,165 @@
+package workers
+
+import (
+	"strconv"
+	"sync"
+	"time"
+
+	ogoutils "github.com/Odinman/ogo/utils"
+	"github.com/Odinman/omq/utils"
+	zmq "github.com/pebbe/zmq4"
+)
+
+const (
+	_REPLIER_IDENTITY = "omq-replier" //异步回复的socket标识, 不参与任务分配
+)
+
+type lockWaiter struct {
+	client   string
+	ls       *LocalStorage
+	deadline time.Time
+}
+
+var (
+	replier *utils.Socket
+
+	lockWaiters     []*lockWaiter
+	lockWaitersLock sync.Mutex
+)
+
+/* {{{ func (ls *LocalStorage) lockArgs() (owner string, ttl int)
+ * 参数为: owner, 租约秒数
+ */
+func (ls *LocalStorage) lockArgs() (owner string, ttl int) {
+	owner, ttl = ls.value, lockTTL
+	if len(ls.args) > 1 {
+		if t, _ := strconv.Atoi(ls.args[1]); t > 0 {
+			ttl = t
+		}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) Lock() (r []string, err error)
+ * 加锁(同一个owner重复加锁为续约), 返回owner以及fencing token, 被其他owner持有返回ErrConflict
+ */
+func (ls *LocalStorage) Lock() (r []string, err error) {
+	owner, ttl := ls.lockArgs()
+	if owner == "" {
+		owner = ogoutils.NewShortUUID()
+		ls.value = owner
+	}
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	var fence int64
+	if fence, err = s.Lock(ls.key, owner, ttl); err == nil {
+		r = []string{owner, strconv.FormatInt(fence, 10)}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) Unlock() (err error)
+ * 解锁, 未锁返回ErrNil, 被其他owner持有返回ErrConflict
+ */
+func (ls *LocalStorage) Unlock() (err error) {
+	var s Storage
+	if s, err = ls.storage(); err == nil {
+		err = s.Unlock(ls.key, ls.value)
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) Renew() (r []string, err error)
+ * 续约, 返回fencing token
+ */
+func (ls *LocalStorage) Renew() (r []string, err error) {
+	owner, ttl := ls.lockArgs()
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	var fence int64
+	if fence, err = s.Renew(ls.key, owner, ttl); err == nil {
+		r = []string{strconv.FormatInt(fence, 10)}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) connectReplier()
+ * 异步回复的socket, 连接backend
+ */
+func (w *OmqWorker) connectReplier() {
+	replier = utils.NewSocket(zmq.DEALER, 50000)
+	replier.SetIdentity(_REPLIER_IDENTITY)
+	replier.Connect("inproc://backend")
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) waitLock(client string, ls *LocalStorage, timeout time.Duration)
+ * 阻塞加锁, 不占用responser, 由newLockWaiter负责回复
+ */
+func (w *OmqWorker) waitLock(client string, ls *LocalStorage, timeout time.Duration) {
+	lockWaitersLock.Lock()
+	defer lockWaitersLock.Unlock()
+	lockWaiters = append(lockWaiters, &lockWaiter{
+		client:   client,
+		ls:       ls,
+		deadline: time.Now().Add(timeout),
+	})
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) newLockWaiter()
+ * 定时为等待者重试加锁, 先来先得
+ */
+func (w *OmqWorker) newLockWaiter() {
+	for now := range time.Tick(100 * time.Millisecond) {
+		lockWaitersLock.Lock()
+		waiters := lockWaiters
+		lockWaitersLock.Unlock()
+		if len(waiters) == 0 {
+			continue
+		}
+
+		done := make(map[*lockWaiter]bool)
+		for _, lw := range waiters {
+			r, err := lw.ls.Lock()
+			switch {
+			case err == nil:
+				w.Debug("lock %s acquired by %s", lw.ls.key, r[0])
+				replier.SendMessage(lw.client, "", RESPONSE_OK, r)
+			case err == ErrConflict && now.Before(lw.deadline):
+				continue
+			case err == ErrConflict:
+				replier.SendMessage(lw.client, "", RESPONSE_CONFLICT)
+			default:
+				replier.SendMessage(lw.client, "", RESPONSE_ERROR, err.Error())
+			}
+			done[lw] = true
+		}
+
+		lockWaitersLock.Lock()
+		remain := lockWaiters[:0]
+		for _, lw := range lockWaiters {
+			if !done[lw] {
+				remain = append(remain, lw)
+			}
+		}
+		lockWaiters = remain
+		lockWaitersLock.Unlock()
+	}
+}
+
+/* }}} */
@@ -0,0 +1,752 @@
+package workers
+
+import (
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+/*
+ * 内存存储, 进程退出后数据丢失, 适合测试以及临时部署
+ * 所有操作在一个锁内完成, 行为与redis一致(过期, 版本号, 空容器删除)
+ */
+
+const (
+	_STORAGE_MEMORY = "memory"
+
+	_MEM_PURGE_INTERVAL = time.Minute //清理过期key的间隔
+)
+
+type memEntry struct {
+	kind     byte //与bolt相同的类型
+	expireAt int64
+	ver      int64
+	str      string
+	hash     map[string]string
+	set      map[string]bool
+	zset     map[string]int64
+}
+
+type memLock struct {
+	owner    string
+	expireAt int64
+}
+
+type memStorage struct {
+	lock   sync.Mutex
+	data   map[string]*memEntry
+	locks  map[string]*memLock
+	fences map[string]int64
+}
+
+var (
+	memStore     *memStorage //进程内只有一份
+	memStoreLock sync.Mutex
+)
+
+func init() {
+	RegisterStorage(_STORAGE_MEMORY, openMemory)
+}
+
+/* {{{ func openMemory(opt *StorageOption) (Storage, error)
+ * 选项无效
+ */
+func openMemory(opt *StorageOption) (Storage, error) {
+	memStoreLock.Lock()
+	defer memStoreLock.Unlock()
+	if memStore == nil {
+		memStore = newMemStorage()
+		go memStore.purge()
+	}
+	return memStore, nil
+}
+
+/* }}} */
+
+/* {{{ func newMemStorage() *memStorage
+ *
+ */
+func newMemStorage() *memStorage {
+	return &memStorage{
+		data:   make(map[string]*memEntry),
+		locks:  make(map[string]*memLock),
+		fences: make(map[string]int64),
+	}
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) purge()
+ * 定期删除过期的key
+ */
+func (ms *memStorage) purge() {
+	for now := range time.Tick(_MEM_PURGE_INTERVAL) {
+		ms.lock.Lock()
+		for k, e := range ms.data {
+			if e.expireAt > 0 && e.expireAt <= now.Unix() {
+				delete(ms.data, k)
+			}
+		}
+		for k, l := range ms.locks {
+			if l.expireAt <= now.Unix() {
+				delete(ms.locks, k)
+			}
+		}
+		ms.lock.Unlock()
+	}
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) get(key string) *memEntry
+ * 未过期的key, 调用者持有锁
+ */
+func (ms *memStorage) get(key string) *memEntry {
+	e, ok := ms.data[key]
+	if !ok {
+		return nil
+	}
+	if e.expireAt > 0 && e.expireAt <= time.Now().Unix() {
+		delete(ms.data, key)
+		return nil
+	}
+	return e
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) typed(key string, kind byte, create bool) (*memEntry, error)
+ * 指定类型的key, 不存在且不创建返回nil, 类型不对返回ErrWrongType
+ */
+func (ms *memStorage) typed(key string, kind byte, create bool) (*memEntry, error) {
+	e := ms.get(key)
+	if e != nil {
+		if e.kind != kind {
+			return nil, ErrWrongType
+		}
+		return e, nil
+	} else if !create {
+		return nil, nil
+	}
+	e = &memEntry{kind: kind}
+	switch kind {
+	case _BOLT_HASH:
+		e.hash = make(map[string]string)
+	case _BOLT_SET:
+		e.set = make(map[string]bool)
+	case _BOLT_ZSET:
+		e.zset = make(map[string]int64)
+	}
+	ms.data[key] = e
+	return e, nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) cleanup(key string, e *memEntry)
+ * 与redis一样, 内容为空的hash/set/zset删除key
+ */
+func (ms *memStorage) cleanup(key string, e *memEntry) {
+	if len(e.hash) == 0 && len(e.set) == 0 && len(e.zset) == 0 {
+		delete(ms.data, key)
+	}
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) set(key, value string, expire int) int64
+ * 覆盖任何类型, 版本号加1, 返回新版本号
+ */
+func (ms *memStorage) set(key, value string, expire int) int64 {
+	ver := int64(1)
+	if e := ms.get(key); e != nil && e.kind == _BOLT_STRING {
+		ver = e.ver + 1
+	}
+	e := &memEntry{kind: _BOLT_STRING, ver: ver, str: value}
+	if expire > 0 {
+		e.expireAt = time.Now().Unix() + int64(expire)
+	}
+	ms.data[key] = e
+	return ver
+}
+
+/* }}} */
+
+/* {{{ func sortSchedules(zset map[string]int64) []string
+ * 按时间排序, 时间相同按member排序(与redis一致)
+ */
+func sortSchedules(zset map[string]int64) []string {
+	members := make([]string, 0, len(zset))
+	for m := range zset {
+		members = append(members, m)
+	}
+	sort.Slice(members, func(i, j int) bool {
+		if zset[members[i]] != zset[members[j]] {
+			return zset[members[i]] < zset[members[j]]
+		}
+		return members[i] < members[j]
+	})
+	return members
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) Get(key string) (string, error)
+ *
+ */
+func (ms *memStorage) Get(key string) (string, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	e := ms.get(key)
+	if e == nil {
+		return "", ErrNil
+	} else if e.kind != _BOLT_STRING {
+		return "", ErrWrongType
+	}
+	return e.str, nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) Set(key, value string, expire int) error
+ *
+ */
+func (ms *memStorage) Set(key, value string, expire int) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	ms.set(key, value, expire)
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) Del(key string) error
+ *
+ */
+func (ms *memStorage) Del(key string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	delete(ms.data, key)
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) Schedule(key, member string, ts int) error
+ *
+ */
+func (ms *memStorage) Schedule(key, member string, ts int) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	e, err := ms.typed(key, _BOLT_ZSET, true)
+	if err != nil {
+		return err
+	}
+	e.zset[member] = int64(ts)
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) Claim(key string, now int64, batch int) (members, scores []string, err error)
+ *
+ */
+func (ms *memStorage) Claim(key string, now int64, batch int) (members, scores []string, err error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	var e *memEntry
+	if e, err = ms.typed(key, _BOLT_ZSET, false); err != nil || e == nil {
+		return
+	}
+	for _, m := range sortSchedules(e.zset) {
+		if len(members) >= batch || e.zset[m] > now {
+			break
+		}
+		members = append(members, m)
+		scores = append(scores, strconv.FormatInt(e.zset[m], 10))
+		delete(e.zset, m)
+	}
+	ms.cleanup(key, e)
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) Unschedule(key string, members ...string) error
+ *
+ */
+func (ms *memStorage) Unschedule(key string, members ...string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	e, err := ms.typed(key, _BOLT_ZSET, false)
+	if err != nil || e == nil {
+		return err
+	}
+	for _, m := range members {
+		delete(e.zset, m)
+	}
+	ms.cleanup(key, e)
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) Reschedule(key, member string, ts int) error
+ *
+ */
+func (ms *memStorage) Reschedule(key, member string, ts int) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	e, err := ms.typed(key, _BOLT_ZSET, false)
+	if err != nil {
+		return err
+	} else if e == nil {
+		return ErrNil
+	} else if _, ok := e.zset[member]; !ok {
+		return ErrNil
+	}
+	e.zset[member] = int64(ts)
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) Schedules(key, min, max string, count int) (members, scores []string, err error)
+ *
+ */
+func (ms *memStorage) Schedules(key, min, max string, count int) (members, scores []string, err error) {
+	var lo, hi int64
+	if lo, err = parseScore(min, true); err != nil {
+		return
+	}
+	if hi, err = parseScore(max, false); err != nil {
+		return
+	}
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	var e *memEntry
+	if e, err = ms.typed(key, _BOLT_ZSET, false); err != nil || e == nil {
+		return
+	}
+	for _, m := range sortSchedules(e.zset) {
+		ts := e.zset[m]
+		if ts < lo {
+			continue
+		} else if ts > hi || (count > 0 && len(members) >= count) {
+			break
+		}
+		members = append(members, m)
+		scores = append(scores, strconv.FormatInt(ts, 10))
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) Exists(key string) (bool, error)
+ *
+ */
+func (ms *memStorage) Exists(key string) (bool, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	return ms.get(key) != nil, nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) TTL(key string) (int64, error)
+ *
+ */
+func (ms *memStorage) TTL(key string) (int64, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	e := ms.get(key)
+	if e == nil {
+		return 0, ErrNil
+	} else if e.expireAt == 0 {
+		return -1, nil
+	}
+	return e.expireAt - time.Now().Unix(), nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) Expire(key string, expire int) error
+ *
+ */
+func (ms *memStorage) Expire(key string, expire int) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	e := ms.get(key)
+	if e == nil {
+		return ErrNil
+	}
+	e.expireAt = 0
+	if expire > 0 {
+		e.expireAt = time.Now().Unix() + int64(expire)
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) Incr(key string, delta int64) (int64, error)
+ *
+ */
+func (ms *memStorage) Incr(key string, delta int64) (int64, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	e, err := ms.typed(key, _BOLT_STRING, true)
+	if err != nil {
+		return 0, err
+	}
+	n := int64(0)
+	if e.ver > 0 { //已存在
+		if n, err = strconv.ParseInt(e.str, 10, 64); err != nil {
+			return 0, fmt.Errorf("value is not an integer")
+		}
+	}
+	n += delta
+	e.ver++
+	e.str = strconv.FormatInt(n, 10)
+	return n, nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) MGet(keys ...string) ([]string, error)
+ *
+ */
+func (ms *memStorage) MGet(keys ...string) ([]string, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	r := make([]string, len(keys))
+	for i, k := range keys {
+		if e := ms.get(k); e != nil && e.kind == _BOLT_STRING {
+			r[i] = e.str
+		}
+	}
+	return r, nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) MSet(pairs ...string) error
+ *
+ */
+func (ms *memStorage) MSet(pairs ...string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	for i := 0; i+1 < len(pairs); i += 2 {
+		ms.set(pairs[i], pairs[i+1], 0)
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) GetV(key string) (string, int64, error)
+ *
+ */
+func (ms *memStorage) GetV(key string) (string, int64, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	e := ms.get(key)
+	if e == nil {
+		return "", 0, ErrNil
+	} else if e.kind != _BOLT_STRING {
+		return "", 0, ErrWrongType
+	}
+	return e.str, e.ver, nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) CAS(key, value, mode, expected string, expire int) (int64, error)
+ * 与redis的_CAS_SCRIPT一致
+ */
+func (ms *memStorage) CAS(key, value, mode, expected string, expire int) (int64, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	e := ms.get(key)
+	if e != nil && e.kind != _BOLT_STRING {
+		return 0, ErrWrongType
+	}
+	switch mode {
+	case CAS_NX:
+		if e != nil {
+			return 0, ErrConflict
+		}
+	case CAS_XX:
+		if e == nil {
+			return 0, ErrConflict
+		}
+	case CAS_VALUE:
+		if (e == nil && expected != "") || (e != nil && e.str != expected) {
+			return 0, ErrConflict
+		}
+	case CAS_VERSION:
+		cur := int64(0)
+		if e != nil {
+			cur = e.ver
+		}
+		if strconv.FormatInt(cur, 10) != expected {
+			return 0, ErrConflict
+		}
+	default:
+		return 0, fmt.Errorf("unknown cas mode: %s", mode)
+	}
+	return ms.set(key, value, expire), nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) HGet(key, field string) (string, error)
+ *
+ */
+func (ms *memStorage) HGet(key, field string) (string, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	e, err := ms.typed(key, _BOLT_HASH, false)
+	if err != nil {
+		return "", err
+	} else if e == nil {
+		return "", ErrNil
+	}
+	v, ok := e.hash[field]
+	if !ok {
+		return "", ErrNil
+	}
+	return v, nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) HSet(key, field, value string) error
+ *
+ */
+func (ms *memStorage) HSet(key, field, value string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	e, err := ms.typed(key, _BOLT_HASH, true)
+	if err != nil {
+		return err
+	}
+	e.hash[field] = value
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) HDel(key string, fields ...string) error
+ *
+ */
+func (ms *memStorage) HDel(key string, fields ...string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	e, err := ms.typed(key, _BOLT_HASH, false)
+	if err != nil || e == nil {
+		return err
+	}
+	for _, f := range fields {
+		delete(e.hash, f)
+	}
+	ms.cleanup(key, e)
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) HGetAll(key string) (map[string]string, error)
+ *
+ */
+func (ms *memStorage) HGetAll(key string) (map[string]string, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	m := make(map[string]string)
+	e, err := ms.typed(key, _BOLT_HASH, false)
+	if err != nil || e == nil {
+		return m, err
+	}
+	for f, v := range e.hash {
+		m[f] = v
+	}
+	return m, nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) SAdd(key string, members ...string) error
+ *
+ */
+func (ms *memStorage) SAdd(key string, members ...string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	e, err := ms.typed(key, _BOLT_SET, true)
+	if err != nil {
+		return err
+	}
+	for _, m := range members {
+		e.set[m] = true
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) SRem(key string, members ...string) error
+ *
+ */
+func (ms *memStorage) SRem(key string, members ...string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	e, err := ms.typed(key, _BOLT_SET, false)
+	if err != nil || e == nil {
+		return err
+	}
+	for _, m := range members {
+		delete(e.set, m)
+	}
+	ms.cleanup(key, e)
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) SMembers(key string) ([]string, error)
+ *
+ */
+func (ms *memStorage) SMembers(key string) ([]string, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	e, err := ms.typed(key, _BOLT_SET, false)
+	if err != nil || e == nil {
+		return nil, err
+	}
+	members := make([]string, 0, len(e.set))
+	for m := range e.set {
+		members = append(members, m)
+	}
+	return members, nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) SIsMember(key, member string) (bool, error)
+ *
+ */
+func (ms *memStorage) SIsMember(key, member string) (bool, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	e, err := ms.typed(key, _BOLT_SET, false)
+	if err != nil || e == nil {
+		return false, err
+	}
+	return e.set[member], nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) held(key string) *memLock
+ * 未过期的锁, 调用者持有锁
+ */
+func (ms *memStorage) held(key string) *memLock {
+	l, ok := ms.locks[key]
+	if !ok || l.expireAt <= time.Now().Unix() {
+		return nil
+	}
+	return l
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) Lock(key, owner string, ttl int) (int64, error)
+ * 与redis的_LOCK_SCRIPT一致
+ */
+func (ms *memStorage) Lock(key, owner string, ttl int) (int64, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	l := ms.held(key)
+	if l != nil && l.owner != owner {
+		return 0, ErrConflict
+	} else if l == nil { //新加锁, fencing token加1
+		ms.fences[key]++
+	}
+	ms.locks[key] = &memLock{owner: owner, expireAt: time.Now().Unix() + int64(ttl)}
+	return ms.fences[key], nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) Unlock(key, owner string) error
+ *
+ */
+func (ms *memStorage) Unlock(key, owner string) error {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	l := ms.held(key)
+	if l == nil {
+		return ErrNil
+	} else if l.owner != owner {
+		return ErrConflict
+	}
+	delete(ms.locks, key)
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) Renew(key, owner string, ttl int) (int64, error)
+ *
+ */
+func (ms *memStorage) Renew(key, owner string, ttl int) (int64, error) {
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	l := ms.held(key)
+	if l == nil {
+		return 0, ErrNil
+	} else if l.owner != owner {
+		return 0, ErrConflict
+	}
+	l.expireAt = time.Now().Unix() + int64(ttl)
+	return ms.fences[key], nil
+}
+
+/* }}} */
+
+/* {{{ func (ms *memStorage) Scan(pattern, cursor string, count int64) (next string, keys []string, err error)
+ * 按key顺序遍历, cursor为下一个key(hex), 与bolt相同
+ */
+func (ms *memStorage) Scan(pattern, cursor string, count int64) (next string, keys []string, err error) {
+	var start []byte
+	if cursor != "0" {
+		if start, err = hex.DecodeString(cursor); err != nil {
+			return "", nil, fmt.Errorf("invalid cursor: %s", cursor)
+		}
+	}
+	ms.lock.Lock()
+	defer ms.lock.Unlock()
+	all := make([]string, 0, len(ms.data))
+	for k := range ms.data {
+		if k >= string(start) {
+			all = append(all, k)
+		}
+	}
+	sort.Strings(all)
+	next = "0"
+	for i, k := range all {
+		if int64(i) >= count {
+			next = hex.EncodeToString([]byte(k))
+			break
+		}
+		if ms.get(k) != nil && globMatch(pattern, k) {
+			keys = append(keys, k)
+		}
+	}
+	return
+}
+
+/* }}} */
@@ -0,0 +1,159 @@
+package workers
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestMemoryTTL(t *testing.T) {
+	ms := newMemStorage()
+	if err := ms.Set("k", "v", 10); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, err := ms.TTL("k"); err != nil || ttl <= 0 || ttl > 10 {
+		t.Fatalf("ttl %d, %v", ttl, err)
+	}
+	if err := ms.Set("forever", "v", 0); err != nil {
+		t.Fatal(err)
+	}
+	if ttl, err := ms.TTL("forever"); err != nil || ttl != -1 {
+		t.Fatalf("ttl without expire %d, %v", ttl, err)
+	}
+
+	// 过期的key读取不到, 也不计入版本号
+	ms.data["k"].expireAt = time.Now().Unix() - 1
+	if _, err := ms.Get("k"); err != ErrNil {
+		t.Fatalf("expired get: %v", err)
+	}
+	if ok, _ := ms.Exists("k"); ok {
+		t.Fatal("expired key exists")
+	}
+	ms.Set("k", "v2", 0)
+	if _, ver, _ := ms.GetV("k"); ver != 1 {
+		t.Fatalf("version after expire %d, want 1", ver)
+	}
+}
+
+func TestMemoryClaim(t *testing.T) {
+	ms := newMemStorage()
+	now := time.Now().Unix()
+	ms.Schedule("q", "c", int(now-1))
+	ms.Schedule("q", "b", int(now-2))
+	ms.Schedule("q", "a", int(now-1)) //时间相同按member排序
+	ms.Schedule("q", "later", int(now+100))
+
+	members, scores, err := ms.Claim("q", now, 2)
+	if err != nil || len(members) != 2 || members[0] != "b" || members[1] != "a" {
+		t.Fatalf("claim %q, %v", members, err)
+	}
+	if scores[0] != strconv.FormatInt(now-2, 10) || scores[1] != strconv.FormatInt(now-1, 10) {
+		t.Fatalf("scores %q", scores)
+	}
+	if members, _, _ = ms.Claim("q", now, 10); len(members) != 1 || members[0] != "c" {
+		t.Fatalf("second claim %q", members)
+	}
+	if members, _, _ = ms.Claim("q", now, 10); len(members) != 0 {
+		t.Fatalf("claimed not due %q", members)
+	}
+	if members, _, _ = ms.Schedules("q", "-inf", "+inf", 0); len(members) != 1 || members[0] != "later" {
+		t.Fatalf("left %q", members)
+	}
+}
+
+func TestMemoryCAS(t *testing.T) {
+	ms := newMemStorage()
+	if _, err := ms.CAS("k", "v1", CAS_XX, "", 0); err != ErrConflict {
+		t.Fatalf("xx on missing key: %v", err)
+	}
+	if ver, err := ms.CAS("k", "v1", CAS_NX, "", 0); err != nil || ver != 1 {
+		t.Fatalf("nx %d, %v", ver, err)
+	}
+	if _, err := ms.CAS("k", "v2", CAS_NX, "", 0); err != ErrConflict {
+		t.Fatalf("nx on existing key: %v", err)
+	}
+	if _, err := ms.CAS("k", "v2", CAS_VALUE, "other", 0); err != ErrConflict {
+		t.Fatalf("cas with wrong value: %v", err)
+	}
+	if ver, err := ms.CAS("k", "v2", CAS_VALUE, "v1", 0); err != nil || ver != 2 {
+		t.Fatalf("cas value %d, %v", ver, err)
+	}
+
+	// 旧版本号不能覆盖
+	if _, err := ms.CAS("k", "v3", CAS_VERSION, "1", 0); err != ErrConflict {
+		t.Fatalf("cas with stale version: %v", err)
+	}
+	if ver, err := ms.CAS("k", "v3", CAS_VERSION, "2", 0); err != nil || ver != 3 {
+		t.Fatalf("cas version %d, %v", ver, err)
+	}
+	if v, ver, err := ms.GetV("k"); err != nil || v != "v3" || ver != 3 {
+		t.Fatalf("getv %q %d, %v", v, ver, err)
+	}
+	ms.HSet("h", "f", "v")
+	if _, _, err := ms.GetV("h"); err != ErrWrongType {
+		t.Fatalf("getv on hash: %v", err)
+	}
+}
+
+func TestMemoryLock(t *testing.T) {
+	ms := newMemStorage()
+	fence, err := ms.Lock("l", "a", 10)
+	if err != nil || fence != 1 {
+		t.Fatalf("lock %d, %v", fence, err)
+	}
+	if _, err := ms.Lock("l", "b", 10); err != ErrConflict {
+		t.Fatalf("locked by other owner: %v", err)
+	}
+	if f, err := ms.Lock("l", "a", 10); err != nil || f != fence { //重入不增加fencing token
+		t.Fatalf("relock %d, %v", f, err)
+	}
+	if _, err := ms.Renew("l", "b", 10); err != ErrConflict {
+		t.Fatalf("renew by other owner: %v", err)
+	}
+	if err := ms.Unlock("l", "b"); err != ErrConflict {
+		t.Fatalf("unlock by other owner: %v", err)
+	}
+	if err := ms.Unlock("l", "a"); err != nil {
+		t.Fatal(err)
+	}
+	if err := ms.Unlock("l", "a"); err != ErrNil {
+		t.Fatalf("unlock twice: %v", err)
+	}
+
+	// 过期之后其他owner可以获得, fencing token递增
+	ms.Lock("l", "a", 10)
+	ms.locks["l"].expireAt = time.Now().Unix() - 1
+	if _, err := ms.Renew("l", "a", 10); err != ErrNil {
+		t.Fatalf("renew expired lock: %v", err)
+	}
+	if f, err := ms.Lock("l", "b", 10); err != nil || f != 3 {
+		t.Fatalf("lock after expire %d, %v", f, err)
+	}
+}
+
+// 通过命令处理(与responser相同的入口)读写内存存储
+func TestMemoryCommands(t *testing.T) {
+	w := newTestWorker(t)
+	if _, err := w.localStorage([]string{COMMAND_SET, "", "k", "v"}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := w.localGet([]string{COMMAND_GETV, "", "k"})
+	if err != nil || len(r) != 2 || r[0] != "v" || r[1] != "1" {
+		t.Fatalf("getv %q, %v", r, err)
+	}
+	if _, err := w.localGet([]string{COMMAND_CAS, "", "k", "v2", CAS_VERSION, "0"}); err != ErrConflict {
+		t.Fatalf("cas with stale version: %v", err)
+	}
+	if _, err := w.localGet([]string{COMMAND_CAS, "", "k", "v2", CAS_VERSION, "1"}); err != nil {
+		t.Fatal(err)
+	}
+	if v := mustGet(t, w, "k"); v != "v2" {
+		t.Fatalf("get %q", v)
+	}
+	if _, err := w.localStorage([]string{COMMAND_DEL, "", "k"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.localGet([]string{COMMAND_GET, "", "k"}); err != ErrNil {
+		t.Fatalf("get after del: %v", err)
+	}
+}
@@ -2,14 +2,11 @@ package workers
 
 import (
 	"fmt"
-	"strings"
 
-	"github.com/Odinman/goutils/zredis"
 	"github.com/Odinman/ogo"
 	"github.com/Odinman/omq/utils"
 	//"../utils"
 	zmq "github.com/pebbe/zmq4"
-	"gopkg.in/redis.v3"
 )
 
 type OmqWorker struct {
@@ -19,8 +16,6 @@ type OmqWorker struct {
 var (
 	publisher *utils.Socket
 	mqpool    *utils.MQPool
-	Redis     *zredis.ZRedis
-	cc        *redis.ClusterClient
 )
 
 func init() {
@@ -33,27 +28,39 @@ func (w *OmqWorker) Main() error {
 
 	// block tasks
 	blockTasks = make(map[string](chan string))
+	tasks = newTaskRegistry()
+	flows = &flowRegistry{flows: make(map[string]*Workflow)}
 
-	// connect local storage
-	if cc = ogo.ClusterClient(); cc == nil {
-		w.Info("not found cluster, use redis")
-		var e error
-		servers := strings.Split(redisAddr, ",")       //支持多个地址,逗号分隔
-		sentinels := strings.Split(redisSentinel, ",") //支持多个地址,逗号分隔
-		if Redis, e = zredis.InitZRedis(servers, sentinels, redisPwd, redisDB, redisMTag); e != nil {
-			//panic(e)
-			w.Error("localstorage unreachable: %s", e)
+	// 节点标识, 没有配置node_id时由网卡地址以及端口生成, 重启后不变(重试等待集合以节点标识区分)
+	// 共用网卡地址的多个实例(比如容器)应该各自配置node_id
+	if nodeId == "" {
+		var err error
+		if nodeId, err = utils.GetLocalIdentity(fmt.Sprint(basePort)); err != nil {
+			return fmt.Errorf("node id: %s", err)
 		}
+		w.Info("node_id not configured, using %s", nodeId)
+	}
+
+	// connect local storage
+	if _, err := getStorage(nil); err != nil {
+		w.Error("localstorage unreachable: %s", err)
 	} else {
-		w.Info("found cluster, gooood!")
+		w.Info("localstorage: %s", storageType)
 	}
 
+	// 存储不可用时的写入缓存
+	w.openJournal()
+
 	// Socket to pub
 	publisher = utils.NewSocket(zmq.PUB, 50000)
 	defer publisher.Close()
 	publisher.Bind(fmt.Sprint("tcp://*:", basePort+1))
 	w.Debug("publisher bind port: %v", basePort+1)
 
+	// Socket to watch
+	w.bindWatcher()
+	defer watcher.Close()
+
 	// Socket to message queuing service
 	// DEALER至少需要一个连接, 否则SendMassage会被block
 	//pusher = NewSocket(zmq.DEALER, 5000)
@@ -67,9 +74,17 @@ func (w *OmqWorker) Main() error {
 	mqpool = utils.NewMQPool()
 	defer mqpool.Destroy()
 
+	// 任务重试
+	go w.newRetrier()
+
+	// 定时触发
+	if scheduler {
+		go w.newScheduler()
+	}
+
 	// 订阅其他server发布的内容
-	if pubAddr != "" {
-		go w.newSubscriber()
+	for _, peer := range remotePeers {
+		go w.newSubscriber(peer)
 	}
 
 	w.serve()
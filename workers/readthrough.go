@@ -0,0 +1,87 @@
+package workers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/Odinman/omq/utils"
+)
+
+/*
+ * GET的StorageOption.ReadThrough为true时, 本地没有则依次查询配置read_peers中的omq
+ * (其他机房刚写入的内容可能还没有同步过来), 查到后按原来的TTL缓存到本地(不发布)
+ */
+
+var (
+	peerPools     = make(map[string]*utils.Pool) //peer => REQ连接池
+	peerPoolsLock sync.Mutex
+)
+
+/* {{{ func peerPool(peer string) *utils.Pool
+ *
+ */
+func peerPool(peer string) *utils.Pool {
+	peerPoolsLock.Lock()
+	defer peerPoolsLock.Unlock()
+	p, ok := peerPools[peer]
+	if !ok {
+		p = utils.NewPool(utils.ReqNewer(fmt.Sprint("tcp://", peer)), 10)
+		peerPools[peer] = p
+	}
+	return p
+}
+
+/* }}} */
+
+/* {{{ func peerRequest(peer string, msg ...interface{}) ([]string, error)
+ *
+ */
+func peerRequest(peer string, msg ...interface{}) ([]string, error) {
+	ps, err := peerPool(peer).Get()
+	if err != nil {
+		return nil, err
+	}
+	defer ps.Close()
+	return ps.Do(time.Duration(readTimeout)*time.Millisecond, msg...)
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) readThrough() (r []string, err error)
+ * 查询其他机房, 都没有返回ErrNil
+ */
+func (ls *LocalStorage) readThrough() (r []string, err error) {
+	// 去掉ReadThrough, 防止peer之间循环查询
+	opt := *ls.option
+	opt.ReadThrough = false
+	option, _ := json.Marshal(opt)
+
+	for _, peer := range readPeers {
+		reply, err := peerRequest(peer, COMMAND_GET, string(option), ls.key)
+		if err != nil || len(reply) < 2 || reply[0] != RESPONSE_OK {
+			continue
+		}
+		value := reply[1]
+
+		// 原来的TTL, 取不到或已经过期的不缓存
+		reply, err = peerRequest(peer, COMMAND_TTL, string(option), ls.key)
+		if err != nil || len(reply) < 2 || reply[0] != RESPONSE_OK {
+			return []string{value}, nil
+		}
+		expire := 0
+		if ttl, _ := strconv.Atoi(reply[1]); ttl > 0 {
+			expire = ttl
+		} else if ttl != -1 {
+			return []string{value}, nil
+		}
+		cache := &LocalStorage{option: ls.option, key: ls.key, value: value, expire: expire}
+		cache.Set()
+		return []string{value}, nil
+	}
+	return nil, ErrNil
+}
+
+/* }}} */
@@ -0,0 +1,229 @@
+package workers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/robfig/cron"
+)
+
+const (
+	_RECUR_KEY_PREFIX = "omq:recur:" //周期定时的定义(hash), 后接定时key
+)
+
+type Recurrence struct {
+	Spec   string //cron表达式(5段), 或者"@every 1h30m"/"@daily"之类
+	Tz     string `json:",omitempty"` //时区, 例如Asia/Shanghai
+	Paused bool
+	Next   int64 //下次触发时间戳
+
+	Compress string `json:",omitempty"` //定时内容的编码, 重新计算时沿用
+	Encrypt  string `json:",omitempty"`
+}
+
+/* {{{ func (r *Recurrence) schedule(from time.Time) (int64, error)
+ * 计算from之后的下一次触发时间
+ */
+func (r *Recurrence) schedule(from time.Time) (int64, error) {
+	sched, err := cron.ParseStandard(r.Spec)
+	if err != nil {
+		return 0, err
+	}
+	if r.Tz != "" {
+		loc, err := time.LoadLocation(r.Tz)
+		if err != nil {
+			return 0, err
+		}
+		from = from.In(loc)
+	}
+	next := sched.Next(from)
+	if next.IsZero() {
+		return 0, fmt.Errorf("no next run for: %s", r.Spec)
+	}
+	return next.Unix(), nil
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) recurKey() string
+ *
+ */
+func (ls *LocalStorage) recurKey() string {
+	return _RECUR_KEY_PREFIX + ls.key
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) loadRecur(member string) (*Recurrence, error)
+ *
+ */
+func (ls *LocalStorage) loadRecur(member string) (*Recurrence, error) {
+	s, err := ls.storage()
+	if err != nil {
+		return nil, err
+	}
+	rj, err := s.HGet(ls.recurKey(), member)
+	if err != nil {
+		return nil, err
+	}
+	r := new(Recurrence)
+	if err := json.Unmarshal([]byte(rj), r); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) saveRecur(member string, r *Recurrence) error
+ *
+ */
+func (ls *LocalStorage) saveRecur(member string, r *Recurrence) error {
+	s, err := ls.storage()
+	if err != nil {
+		return err
+	}
+	rj, _ := json.Marshal(r)
+	return s.HSet(ls.recurKey(), member, string(rj))
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) arm(member string, r *Recurrence) error
+ * 计算下次触发时间, 放入定时集合
+ */
+func (ls *LocalStorage) arm(member string, r *Recurrence) error {
+	next, err := r.schedule(time.Now())
+	if err != nil {
+		return err
+	}
+	r.Next = next
+	if err := ls.saveRecur(member, r); err != nil {
+		return err
+	}
+	return ls.entry(member, r, int(next)).Schedule()
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) entry(member string, r *Recurrence, ts int) *LocalStorage
+ * 定时集合中的内容, 按定义时的选项编码(TIMING等命令的选项可能不同)
+ */
+func (ls *LocalStorage) entry(member string, r *Recurrence, ts int) *LocalStorage {
+	opt := StorageOption{}
+	if ls.option != nil {
+		opt = *ls.option
+	}
+	if r != nil {
+		opt.Compress, opt.Encrypt = r.Compress, r.Encrypt
+	}
+	return &LocalStorage{option: &opt, key: ls.key, value: member, ts: ts}
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) Recur() error
+ * 新建(或覆盖)周期定时
+ */
+func (ls *LocalStorage) Recur() error {
+	if ls.spec == "" {
+		return fmt.Errorf("recur spec empty")
+	}
+	r := &Recurrence{Spec: ls.spec, Tz: ls.tz}
+	if ls.option != nil {
+		r.Compress, r.Encrypt = ls.option.Compress, ls.option.Encrypt
+	}
+	if err := ls.arm(ls.value, r); err != nil {
+		return err
+	}
+	return ls.register()
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) Unrecur() error
+ * 删除周期定时
+ */
+func (ls *LocalStorage) Unrecur() error {
+	s, err := ls.storage()
+	if err != nil {
+		return err
+	}
+	r, _ := ls.loadRecur(ls.value) //取不到时按命令的选项编码
+	if err := s.HDel(ls.recurKey(), ls.value); err != nil {
+		return err
+	}
+	return ls.entry(ls.value, r, 0).Unschedule()
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) PauseRecur(pause bool) error
+ * 暂停/恢复周期定时
+ */
+func (ls *LocalStorage) PauseRecur(pause bool) error {
+	r, err := ls.loadRecur(ls.value)
+	if err != nil {
+		return err
+	}
+	r.Paused = pause
+	if pause {
+		if err := ls.saveRecur(ls.value, r); err != nil {
+			return err
+		}
+		return ls.entry(ls.value, r, 0).Unschedule()
+	}
+	return ls.arm(ls.value, r)
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) Recurs() (r []string, err error)
+ * 列出key下所有周期定时, 每项2帧: value, 定义(json)
+ */
+func (ls *LocalStorage) Recurs() (r []string, err error) {
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	var all map[string]string
+	if all, err = s.HGetAll(ls.recurKey()); err != nil {
+		return
+	} else if len(all) == 0 {
+		return nil, ErrNil
+	}
+	members := make([]string, 0, len(all))
+	for m := range all {
+		members = append(members, m)
+	}
+	sort.Strings(members)
+	r = make([]string, 0, len(all)*2)
+	for _, m := range members {
+		r = append(r, m, all[m])
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) rearm(members []string)
+ * 定时触发之后, 周期定时重新计算下次时间
+ */
+func (ls *LocalStorage) rearm(members []string) {
+	s, err := ls.storage()
+	if err != nil {
+		return
+	}
+	if ok, _ := s.Exists(ls.recurKey()); !ok {
+		return
+	}
+	for _, m := range members {
+		if r, err := ls.loadRecur(m); err == nil && !r.Paused {
+			ls.arm(m, r)
+		}
+	}
+}
+
+/* }}} */
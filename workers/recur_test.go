@@ -0,0 +1,77 @@
+package workers
+
+import (
+	"strconv"
+	"testing"
+	"time"
+)
+
+func scheduledAt(t *testing.T, w *OmqWorker, key, member string) int64 {
+	r, err := w.localGet([]string{COMMAND_SCHEDULES, "", key})
+	if err == ErrNil {
+		return 0
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i+1 < len(r); i += 2 {
+		if r[i] == member {
+			ts, _ := strconv.ParseInt(r[i+1], 10, 64)
+			return ts
+		}
+	}
+	return 0
+}
+
+func testRearm(t *testing.T, option string) {
+	w := newTestWorker(t)
+	if _, err := w.localStorage([]string{COMMAND_RECUR, option, "cron", "job", "@every 1h"}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	if ts := scheduledAt(t, w, "cron", "job"); ts < now+3500 || ts > now+3700 {
+		t.Fatalf("first run at %d, now %d", ts, now)
+	}
+
+	// 提前到期, TIMING领取之后按定义重新计算下次时间(TIMING不带编码选项)
+	if _, err := w.localStorage([]string{COMMAND_RESCHEDULE, option, "cron", "job", strconv.FormatInt(now-1, 10)}); err != nil {
+		t.Fatal(err)
+	}
+	r, err := w.localGet([]string{COMMAND_TIMING, "", "cron"})
+	if err != nil || len(r) != 1 || r[0] != "job" {
+		t.Fatalf("timing %q, %v", r, err)
+	}
+	if ts := scheduledAt(t, w, "cron", "job"); ts < now+3500 {
+		t.Fatalf("not rearmed: %d", ts)
+	}
+
+	// 暂停之后领取不到, 也不会重新计算
+	if _, err := w.localStorage([]string{COMMAND_RECURPAUSE, "", "cron", "job"}); err != nil {
+		t.Fatal(err)
+	}
+	if ts := scheduledAt(t, w, "cron", "job"); ts != 0 {
+		t.Fatalf("paused but scheduled at %d", ts)
+	}
+	if _, err := w.localStorage([]string{COMMAND_RECURRESUME, "", "cron", "job"}); err != nil {
+		t.Fatal(err)
+	}
+	if ts := scheduledAt(t, w, "cron", "job"); ts < now+3500 {
+		t.Fatalf("not resumed: %d", ts)
+	}
+
+	// 删除之后定时也取消
+	if _, err := w.localStorage([]string{COMMAND_RECURDEL, "", "cron", "job"}); err != nil {
+		t.Fatal(err)
+	}
+	if ts := scheduledAt(t, w, "cron", "job"); ts != 0 {
+		t.Fatalf("deleted but scheduled at %d", ts)
+	}
+}
+
+func TestRecurRearm(t *testing.T) {
+	testRearm(t, "")
+}
+
+func TestRecurRearmEncoded(t *testing.T) {
+	setTestKeyring(t)
+	testRearm(t, `{"Encrypt":"k1","Compress":"snappy"}`)
+}
@@ -0,0 +1,342 @@
+package workers
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/Odinman/ogo"
+)
+
+const (
+	_STORAGE_CLUSTER  = "cluster"  //redis集群
+	_STORAGE_SENTINEL = "sentinel" //redis(sentinel)
+
+	// 领取到期内容: ARGV[1]为当前时间戳, ARGV[2]为数量
+	_TIMING_SCRIPT = `
+local items = redis.call('ZRANGEBYSCORE', KEYS[1], '-inf', ARGV[1], 'WITHSCORES', 'LIMIT', 0, ARGV[2])
+for i = 1, #items, 2 do
+	redis.call('ZREM', KEYS[1], items[i])
+end
+return items`
+
+	// 只修改已存在的定时: ARGV[1]为新时间戳, ARGV[2]为value
+	_RESCHEDULE_SCRIPT = `
+if redis.call('ZSCORE', KEYS[1], ARGV[2]) then
+	return redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+end
+return nil`
+
+	// 版本号: 值不存在时版本为0, 每次写入加1
+	// KEYS[1]为key, KEYS[2]为版本key; ARGV[1]为value, ARGV[2]为过期秒数
+	_SET_SCRIPT = `
+local ver = 1
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	ver = (tonumber(redis.call('GET', KEYS[2])) or 0) + 1
+end
+if tonumber(ARGV[2]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+	redis.call('SET', KEYS[2], ver, 'EX', ARGV[2])
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+	redis.call('SET', KEYS[2], ver)
+end
+return ver`
+
+	// KEYS为key,版本key成对, ARGV为对应的value
+	_MSET_SCRIPT = `
+for i = 1, #KEYS, 2 do
+	local ver = 1
+	if redis.call('EXISTS', KEYS[i]) == 1 then
+		ver = (tonumber(redis.call('GET', KEYS[i+1])) or 0) + 1
+	end
+	redis.call('SET', KEYS[i], ARGV[(i+1)/2])
+	redis.call('SET', KEYS[i+1], ver)
+end
+return #ARGV`
+
+	// ARGV[1]为增量
+	_INCR_SCRIPT = `
+local ver = 1
+if redis.call('EXISTS', KEYS[1]) == 1 then
+	ver = (tonumber(redis.call('GET', KEYS[2])) or 0) + 1
+end
+local n = redis.call('INCRBY', KEYS[1], ARGV[1])
+redis.call('SET', KEYS[2], ver)
+return n`
+
+	// ARGV[1]为value, ARGV[2]为模式, ARGV[3]为期望值, ARGV[4]为过期秒数
+	// 条件不满足返回-1, 否则返回新版本号
+	_CAS_SCRIPT = `
+local cur = redis.call('GET', KEYS[1])
+local ver = 0
+if cur then
+	ver = tonumber(redis.call('GET', KEYS[2])) or 0
+end
+local mode = ARGV[2]
+if mode == 'NX' then
+	if cur then return -1 end
+elseif mode == 'XX' then
+	if not cur then return -1 end
+elseif mode == 'VALUE' then
+	if (cur or '') ~= ARGV[3] then return -1 end
+elseif mode == 'VERSION' then
+	if ver ~= tonumber(ARGV[3]) then return -1 end
+else
+	return redis.error_reply('unknown cas mode: ' .. mode)
+end
+ver = ver + 1
+if tonumber(ARGV[4]) > 0 then
+	redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[4])
+	redis.call('SET', KEYS[2], ver, 'EX', ARGV[4])
+else
+	redis.call('SET', KEYS[1], ARGV[1])
+	redis.call('SET', KEYS[2], ver)
+end
+return ver`
+
+	// 返回{value, 版本号}
+	_GETV_SCRIPT = `
+local v = redis.call('GET', KEYS[1])
+if not v then return nil end
+return {v, redis.call('GET', KEYS[2]) or '0'}`
+
+	// KEYS[1]为锁, KEYS[2]为fencing计数器; ARGV[1]为owner, ARGV[2]为租约秒数
+	// 被其他owner持有返回-1, 否则返回fencing token
+	_LOCK_SCRIPT = `
+local cur = redis.call('GET', KEYS[1])
+if cur and cur ~= ARGV[1] then return -1 end
+if not cur then
+	redis.call('SET', KEYS[1], ARGV[1], 'EX', ARGV[2])
+	return redis.call('INCR', KEYS[2])
+end
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+return tonumber(redis.call('GET', KEYS[2]) or '0')`
+
+	// 未锁返回0, 被其他owner持有返回-1
+	_UNLOCK_SCRIPT = `
+local cur = redis.call('GET', KEYS[1])
+if not cur then return 0 end
+if cur ~= ARGV[1] then return -1 end
+redis.call('DEL', KEYS[1])
+return 1`
+
+	_RENEW_SCRIPT = `
+local cur = redis.call('GET', KEYS[1])
+if not cur then return 0 end
+if cur ~= ARGV[1] then return -1 end
+redis.call('EXPIRE', KEYS[1], ARGV[2])
+return tonumber(redis.call('GET', KEYS[2]) or '0')`
+)
+
+/*
+ * 两种redis实现共用的lua脚本操作
+ * eval: 整数返回int64, 字符串返回string, 数组返回[]interface{}, nil返回ErrNil
+ */
+type redisScripts struct {
+	eval func(script string, keys []string, args ...string) (interface{}, error)
+}
+
+func init() {
+	RegisterStorage(_STORAGE_REDIS, openRedis)
+}
+
+/* {{{ func openRedis(opt *StorageOption) (Storage, error)
+ * 有集群用集群, 否则用sentinel
+ */
+func openRedis(opt *StorageOption) (Storage, error) {
+	if c := ogo.ClusterClient(); c != nil {
+		return openCluster(opt)
+	}
+	return openSentinel(opt)
+}
+
+/* }}} */
+
+/* {{{ func hashTagged(key string) string
+ * 保证衍生的key与原key在同一个slot: 原key已经有hash tag的沿用(后面加后缀不影响tag), 否则整个key作为tag
+ * (没有有效tag但包含"}"的key无法保证, 集群中应避免)
+ */
+func hashTagged(key string) string {
+	if i := strings.IndexByte(key, '{'); i >= 0 {
+		if j := strings.IndexByte(key[i+1:], '}'); j > 0 {
+			return key
+		}
+	}
+	return "{" + key + "}"
+}
+
+/* }}} */
+
+/* {{{ func versionKey(key string) string
+ * 版本号key, 使用hash tag保证与key在同一个slot
+ */
+func versionKey(key string) string {
+	return hashTagged(key) + ":omq:ver"
+}
+
+/* }}} */
+
+/* {{{ func lockKeys(key string) []string
+ * 锁以及fencing计数器, 使用hash tag保证在同一个slot
+ */
+func lockKeys(key string) []string {
+	tagged := hashTagged(key)
+	return []string{tagged + ":omq:lock", tagged + ":omq:fence"}
+}
+
+/* }}} */
+
+/* {{{ func (rs redisScripts) Set(key, value string, expire int) (err error)
+ * 写入同时增加版本号
+ */
+func (rs redisScripts) Set(key, value string, expire int) (err error) {
+	_, err = rs.eval(_SET_SCRIPT, []string{key, versionKey(key)}, value, strconv.Itoa(expire))
+	return
+}
+
+/* }}} */
+
+/* {{{ func (rs redisScripts) Claim(key string, now int64, batch int) (members, scores []string, err error)
+ * 用lua脚本取出并删除到期内容, 保证多个omq同时领取时每项只被领取一次
+ */
+func (rs redisScripts) Claim(key string, now int64, batch int) (members, scores []string, err error) {
+	var result interface{}
+	if result, err = rs.eval(_TIMING_SCRIPT, []string{key}, strconv.FormatInt(now, 10), strconv.Itoa(batch)); err != nil {
+		if err == ErrNil {
+			err = nil
+		}
+		return
+	}
+	items, ok := result.([]interface{})
+	if !ok {
+		return nil, nil, fmt.Errorf("unknown type")
+	}
+	members = make([]string, 0, len(items)/2)
+	scores = make([]string, 0, len(items)/2)
+	for i := 0; i+1 < len(items); i += 2 {
+		m, _ := items[i].(string)
+		sc, _ := items[i+1].(string)
+		members = append(members, m)
+		scores = append(scores, sc)
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (rs redisScripts) Reschedule(key, member string, ts int) (err error)
+ *
+ */
+func (rs redisScripts) Reschedule(key, member string, ts int) (err error) {
+	_, err = rs.eval(_RESCHEDULE_SCRIPT, []string{key}, strconv.Itoa(ts), member)
+	return
+}
+
+/* }}} */
+
+/* {{{ func (rs redisScripts) Incr(key string, delta int64) (int64, error)
+ *
+ */
+func (rs redisScripts) Incr(key string, delta int64) (int64, error) {
+	result, err := rs.eval(_INCR_SCRIPT, []string{key, versionKey(key)}, strconv.FormatInt(delta, 10))
+	if err != nil {
+		return 0, err
+	}
+	n, _ := result.(int64)
+	return n, nil
+}
+
+/* }}} */
+
+/* {{{ func (rs redisScripts) GetV(key string) (value string, ver int64, err error)
+ *
+ */
+func (rs redisScripts) GetV(key string) (value string, ver int64, err error) {
+	var result interface{}
+	if result, err = rs.eval(_GETV_SCRIPT, []string{key, versionKey(key)}); err != nil {
+		return
+	}
+	items, _ := result.([]interface{})
+	if len(items) != 2 {
+		return "", 0, ErrNil
+	}
+	value = fmt.Sprint(items[0])
+	ver, _ = strconv.ParseInt(fmt.Sprint(items[1]), 10, 64)
+	return
+}
+
+/* }}} */
+
+/* {{{ func (rs redisScripts) CAS(key, value, mode, expected string, expire int) (int64, error)
+ *
+ */
+func (rs redisScripts) CAS(key, value, mode, expected string, expire int) (int64, error) {
+	result, err := rs.eval(_CAS_SCRIPT, []string{key, versionKey(key)}, value, mode, expected, strconv.Itoa(expire))
+	if err != nil {
+		return 0, err
+	}
+	ver, _ := result.(int64)
+	if ver < 0 {
+		return 0, ErrConflict
+	}
+	return ver, nil
+}
+
+/* }}} */
+
+/* {{{ func (rs redisScripts) Lock(key, owner string, ttl int) (int64, error)
+ *
+ */
+func (rs redisScripts) Lock(key, owner string, ttl int) (int64, error) {
+	result, err := rs.eval(_LOCK_SCRIPT, lockKeys(key), owner, strconv.Itoa(ttl))
+	if err != nil {
+		return 0, err
+	}
+	fence, _ := result.(int64)
+	if fence < 0 {
+		return 0, ErrConflict
+	}
+	return fence, nil
+}
+
+/* }}} */
+
+/* {{{ func (rs redisScripts) Unlock(key, owner string) (err error)
+ *
+ */
+func (rs redisScripts) Unlock(key, owner string) (err error) {
+	var result interface{}
+	if result, err = rs.eval(_UNLOCK_SCRIPT, lockKeys(key), owner); err != nil {
+		return
+	}
+	switch n, _ := result.(int64); n {
+	case 0:
+		err = ErrNil
+	case -1:
+		err = ErrConflict
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (rs redisScripts) Renew(key, owner string, ttl int) (int64, error)
+ *
+ */
+func (rs redisScripts) Renew(key, owner string, ttl int) (int64, error) {
+	result, err := rs.eval(_RENEW_SCRIPT, lockKeys(key), owner, strconv.Itoa(ttl))
+	if err != nil {
+		return 0, err
+	}
+	switch n, _ := result.(int64); n {
+	case 0:
+		return 0, ErrNil
+	case -1:
+		return 0, ErrConflict
+	default:
+		return n, nil
+	}
+}
+
+/* }}} */
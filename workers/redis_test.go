@@ -0,0 +1,17 @@
+package workers
+
+import "testing"
+
+func TestHashTagged(t *testing.T) {
+	for key, want := range map[string]string{
+		"user:1":        "{user:1}:omq:ver",
+		"{user:1}:name": "{user:1}:name:omq:ver", //沿用原来的tag
+	} {
+		if got := versionKey(key); got != want {
+			t.Errorf("versionKey(%q) = %q, want %q", key, got, want)
+		}
+	}
+	if keys := lockKeys("{order}:1"); keys[0] != "{order}:1:omq:lock" || keys[1] != "{order}:1:omq:fence" {
+		t.Errorf("lockKeys: %q", keys)
+	}
+}
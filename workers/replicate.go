@@ -0,0 +1,87 @@
+package workers
+
+import (
+	"sync"
+
+	ogoutils "github.com/Odinman/ogo/utils"
+)
+
+/*
+ * 复制到其他机房的命令带上来源节点以及操作id: _REPLICATE_MARK, origin, op id, 命令...
+ * 订阅者丢弃本节点发出的以及已经处理过的命令, 配置replicate_relay时转发给自己的订阅者
+ * 没有标记的命令(旧版本omq发出)照常处理, 但不转发
+ * 旧版本omq不认识带标记的命令, 所以默认仍然发布旧格式; 所有机房升级后再配置replicate_tagged
+ */
+
+const (
+	_REPLICATE_MARK = "\003" //复制命令的第一帧
+
+	_SEEN_OPS = 100000 //记住最近处理过的操作id数
+)
+
+type seenOps struct {
+	lock sync.Mutex
+	ids  map[string]bool
+	ring []string //按处理顺序, 满了之后覆盖最早的
+	next int
+}
+
+var seen = &seenOps{ids: make(map[string]bool), ring: make([]string, _SEEN_OPS)}
+
+/* {{{ func (s *seenOps) check(id string) bool
+ * 第一次见到返回true, 同时记住
+ */
+func (s *seenOps) check(id string) bool {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	if s.ids[id] {
+		return false
+	}
+	if old := s.ring[s.next]; old != "" {
+		delete(s.ids, old)
+	}
+	s.ring[s.next] = id
+	s.next = (s.next + 1) % len(s.ring)
+	s.ids[id] = true
+	return true
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) publish(cmd []string)
+ * 发布(目标是跨IDC多点发布), 配置replicate_tagged时带上本节点标识以及操作id
+ */
+func (w *OmqWorker) publish(cmd []string) {
+	if !replicateTagged { //旧格式
+		publisher.SendMessage(cmd)
+		return
+	}
+	opId := ogoutils.NewShortUUID()
+	seen.check(opId)
+	publisher.SendMessage(_REPLICATE_MARK, nodeId, opId, cmd)
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) replicated(msg []string) (cmd []string, relay bool)
+ * 解析订阅收到的命令, 需要丢弃的返回nil
+ */
+func (w *OmqWorker) replicated(msg []string) (cmd []string, relay bool) {
+	if msg[0] != _REPLICATE_MARK { //旧版本
+		return msg, false
+	} else if len(msg) < 4 {
+		w.Debug("invalid replicated message: %q", msg)
+		return nil, false
+	}
+	origin, opId := msg[1], msg[2]
+	if origin == nodeId { //本节点发出, 绕回来了
+		w.Trace("drop looped op %s", opId)
+		return nil, false
+	} else if !seen.check(opId) { //从其他路径已经收到过
+		w.Trace("drop duplicate op %s from %s", opId, origin)
+		return nil, false
+	}
+	return msg[3:], replicateRelay
+}
+
+/* }}} */
@@ -0,0 +1,52 @@
+package workers
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestSeenOps(t *testing.T) {
+	s := &seenOps{ids: make(map[string]bool), ring: make([]string, 3)}
+	if !s.check("a") || s.check("a") {
+		t.Fatal("duplicate not detected")
+	}
+	s.check("b")
+	s.check("c")
+	s.check("d") //覆盖最早的a
+	if !s.check("a") {
+		t.Fatal("oldest id not forgotten")
+	}
+	if len(s.ids) != 3 {
+		t.Fatalf("remember %d ids, want 3", len(s.ids))
+	}
+}
+
+func TestReplicated(t *testing.T) {
+	w := &OmqWorker{}
+	nodeId = "local"
+	seen = &seenOps{ids: make(map[string]bool), ring: make([]string, _SEEN_OPS)}
+	replicateRelay = true
+	defer func() { replicateRelay = false }()
+
+	set := []string{COMMAND_SET, "", "k", "v"}
+	tagged := func(origin, op string) []string {
+		return append([]string{_REPLICATE_MARK, origin, op}, set...)
+	}
+
+	// 旧格式照常处理, 不转发
+	if cmd, relay := w.replicated(set); len(cmd) != 4 || relay {
+		t.Fatalf("legacy: %q, %v", cmd, relay)
+	}
+	if cmd, relay := w.replicated(tagged("peer", "op1")); fmt.Sprint(cmd) != fmt.Sprint(set) || !relay {
+		t.Fatalf("tagged: %q, %v", cmd, relay)
+	}
+	if cmd, _ := w.replicated(tagged("peer2", "op1")); cmd != nil {
+		t.Fatal("duplicate op applied")
+	}
+	if cmd, _ := w.replicated(tagged("local", "op2")); cmd != nil {
+		t.Fatal("looped op applied")
+	}
+	if cmd, _ := w.replicated([]string{_REPLICATE_MARK, "peer"}); cmd != nil {
+		t.Fatal("invalid message applied")
+	}
+}
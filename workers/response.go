@@ -1,6 +1,7 @@
 package workers
 
 import (
+	"encoding/json"
 	"strconv"
 	"strings"
 	"time"
@@ -70,7 +71,9 @@ func (w *OmqWorker) newResponser(i int) {
 				act := strings.ToUpper(cmd[0])
 				key := cmd[1]
 				switch act {
-				case COMMAND_GET, COMMAND_TIMING: //获取key内容
+				case COMMAND_GET, COMMAND_TIMING, COMMAND_RECURLIST, COMMAND_SCHEDULES,
+					COMMAND_EXISTS, COMMAND_TTL, COMMAND_MGET, COMMAND_GETV, COMMAND_SCAN,
+					COMMAND_HGET, COMMAND_HGETALL, COMMAND_SMEMBERS, COMMAND_SISMEMBER: //获取key内容
 					if r, err := w.localGet(cmd); err != nil {
 						w.Debug("error: %s", err)
 						if err == ErrNil {
@@ -82,42 +85,147 @@ func (w *OmqWorker) newResponser(i int) {
 						w.Trace("response: %s, len: %d", r, len(r))
 						node.SendMessage(client, "", RESPONSE_OK, r) //回复REQ,因此要加上一个空帧
 					}
-				case COMMAND_SET, COMMAND_DEL, COMMAND_SCHEDULE: //key-value命令
+				case COMMAND_HEALTH: //存储健康状态
+					node.SendMessage(client, "", RESPONSE_OK, health())
+				case COMMAND_CACHESTATS: //GET缓存统计
+					node.SendMessage(client, "", RESPONSE_OK, gcache.stats())
+				case COMMAND_PEERS: //订阅的其他机房状态
+					node.SendMessage(client, "", RESPONSE_OK, peersStatus())
+				case COMMAND_INCR: //有返回值的修改命令
+					if r, err := w.localGet(cmd); err != nil {
+						w.Debug("error: %s", err)
+						node.SendMessage(client, "", RESPONSE_ERROR, err.Error())
+					} else {
+						node.SendMessage(client, "", RESPONSE_OK, r)
+						w.notifyWatch(COMMAND_INCR, &LocalStorage{key: cmd[2], args: r})
+						// 发布(目标是跨IDC多点发布)
+						w.publish(cmd)
+					}
+				case COMMAND_LOCK, COMMAND_UNLOCK, COMMAND_RENEW: //锁(只在本机房有效, 不发布)
+					if r, err := w.localGet(cmd); err == ErrConflict {
+						node.SendMessage(client, "", RESPONSE_CONFLICT)
+					} else if err == ErrNil {
+						node.SendMessage(client, "", RESPONSE_NIL)
+					} else if err != nil {
+						w.Debug("error: %s", err)
+						node.SendMessage(client, "", RESPONSE_ERROR, err.Error())
+					} else {
+						node.SendMessage(client, "", RESPONSE_OK, r)
+					}
+				case COMMAND_BLOCK: //阻塞加锁, 等待期间不占用responser
+					if len(cmd) < 3 {
+						node.SendMessage(client, "", RESPONSE_ERROR)
+						break
+					}
+					ls := w.newLocalStorage(cmd)
+					timeout := BTASK_TIMEOUT
+					if len(cmd) > 5 {
+						if ts, _ := strconv.Atoi(cmd[5]); ts > 0 {
+							timeout = time.Duration(ts) * time.Second
+						}
+					}
+					if r, err := ls.Lock(); err == ErrConflict {
+						w.Debug("lock %s busy, waiting %s", ls.key, timeout)
+						w.waitLock(client, ls, timeout)
+						node.Send(PPP_READY, 0) //没有回复, 告诉queue可以继续分配
+					} else if err != nil {
+						w.Debug("error: %s", err)
+						node.SendMessage(client, "", RESPONSE_ERROR, err.Error())
+					} else {
+						node.SendMessage(client, "", RESPONSE_OK, r)
+					}
+				case COMMAND_CAS: //条件写入, 返回新版本号
+					if r, err := w.localGet(cmd); err == ErrConflict {
+						node.SendMessage(client, "", RESPONSE_CONFLICT)
+					} else if err != nil {
+						w.Debug("error: %s", err)
+						node.SendMessage(client, "", RESPONSE_ERROR, err.Error())
+					} else {
+						node.SendMessage(client, "", RESPONSE_OK, r)
+						// 其他机房直接写入结果
+						set := []string{COMMAND_SET, cmd[1], cmd[2], cmd[3]}
+						if len(cmd) > 6 {
+							set = append(set, cmd[6])
+						}
+						w.notifyWatch(COMMAND_SET, &LocalStorage{key: cmd[2], args: cmd[3:4]})
+						w.publish(set)
+					}
+				case COMMAND_SET, COMMAND_DEL, COMMAND_EXPIRE, COMMAND_MSET,
+					COMMAND_HSET, COMMAND_HDEL, COMMAND_SADD, COMMAND_SREM,
+					COMMAND_SCHEDULE, COMMAND_UNSCHEDULE, COMMAND_RESCHEDULE,
+					COMMAND_RECUR, COMMAND_RECURDEL, COMMAND_RECURPAUSE, COMMAND_RECURRESUME: //key-value命令
 					// 存到本地存储(同步)
 					//回复结果(带信封, 否则找不到发送者), 因为是异步的, 可以先回复, 再做事
-					if err := w.localStorage(cmd); err != nil {
+					rep, err := w.localStorage(cmd)
+					if err == ErrNil {
+						node.SendMessage(client, "", RESPONSE_NIL) //不存在
+					} else if err != nil {
 						w.Debug("error: %s", err)
 						node.SendMessage(client, "", RESPONSE_ERROR, err.Error()) //回复REQ,因此要加上一个空帧
 					} else {
 						node.SendMessage(client, "", RESPONSE_OK) //回复REQ,因此要加上一个空帧
 					}
 
-					// 发布(目标是跨IDC多点发布)
-					publisher.SendMessage(cmd)
+					// 发布(目标是跨IDC多点发布), 编码后的值; 编码失败的不发布
+					if rep != nil {
+						w.publish(rep)
+					}
 
-				case COMMAND_PUSH, COMMAND_TASK: //任务队列命令
-					value := cmd[2:]
-					if err := mqpool.Push(key, value); err == nil {
+				case COMMAND_PUSH: //任务队列命令
+					if id, err := w.pushPlain(key, cmd[2:]); err != nil {
+						w.Debug("push %s failed: %s", key, err)
+						node.SendMessage(client, "", RESPONSE_ERROR, err.Error())
+					} else if id != "" && replyTaskId {
+						w.Debug("push %s successful, task id: %s", key, id)
+						node.SendMessage(client, "", RESPONSE_OK, id)
+					} else {
 						w.Debug("push %s successful", key)
 						node.SendMessage(client, "", RESPONSE_OK)
+					}
+				case COMMAND_TASK: //需要跟踪状态的任务, 配置reply_task_id时返回任务id
+					t := &Task{Id: ogoutils.NewShortUUID(), Queue: key, Value: cmd[2:], plain: true}
+					if err := w.pushTask(t); err == nil {
+						w.Debug("push %s successful, task id: %s", key, t.Id)
+						if replyTaskId {
+							node.SendMessage(client, "", RESPONSE_OK, t.Id)
+						} else {
+							node.SendMessage(client, "", RESPONSE_OK)
+						}
 					} else {
 						w.Debug("push %s failed: %s", key, err)
 						node.SendMessage(client, "", RESPONSE_ERROR, err.Error())
 					}
+				case COMMAND_RTASK: //可重试任务, 领取到的第一帧为任务id
+					if len(cmd) > 3 {
+						if t, err := newTask(ogoutils.NewShortUUID(), key, cmd[2], cmd[3:]); err != nil {
+							node.SendMessage(client, "", RESPONSE_ERROR, err.Error())
+						} else if err := w.pushTask(t); err != nil {
+							w.Debug("push %s failed: %s", key, err)
+							node.SendMessage(client, "", RESPONSE_ERROR, err.Error())
+						} else {
+							w.Debug("push retry task %s successful, task id: %s", key, t.Id)
+							node.SendMessage(client, "", RESPONSE_OK, t.Id)
+						}
+					} else {
+						node.SendMessage(client, "", RESPONSE_ERROR)
+					}
 				case COMMAND_BTASK: //阻塞任务队列命令
-					value := cmd[2:]
 					taskId := ogoutils.NewShortUUID()
-					value = append([]string{taskId}, value...) //放前面
-					if err := mqpool.Push(key, value); err == nil {
+					t, _ := newTask(taskId, key, "", cmd[2:])
+					done := make(chan string, 1)
+					blockLock.Lock()
+					blockTasks[taskId] = done
+					blockLock.Unlock()
+					if err := w.pushTask(t); err == nil {
 						w.Debug("push block task %s successful, task id: %s [%s]", key, taskId, time.Now())
-						blockTasks[taskId] = make(chan string, 1)
 						bto := time.Tick(BTASK_TIMEOUT)
 						//go w.newBlocker(client)
 						select {
 						case <-bto: //超时
 							w.Info("waiting time out")
+							w.endTask(taskId, TASK_TIMEOUT, "")
 							node.SendMessage(client, "", RESPONSE_ERROR)
-						case result := <-blockTasks[taskId]:
+						case result := <-done:
 							w.Debug("block task result: %s [%s]", result, time.Now())
 							if result == "0" {
 								node.SendMessage(client, "", RESPONSE_ERROR)
@@ -125,26 +233,88 @@ func (w *OmqWorker) newResponser(i int) {
 								node.SendMessage(client, "", RESPONSE_OK, result)
 							}
 						}
-						delete(blockTasks, taskId)
 					} else {
 						w.Debug("push %s failed: %s", key, err)
 						node.SendMessage(client, "", RESPONSE_ERROR, err.Error())
 					}
-				case COMMAND_COMPLETE: // 完成阻塞任务
-					if len(cmd) > 3 {
-						if taskId := cmd[2]; taskId != "" {
-							if _, ok := blockTasks[taskId]; ok {
-								blockTasks[taskId] <- cmd[3]
-								node.SendMessage(client, "", RESPONSE_OK)
-							} else {
-								node.SendMessage(client, "", RESPONSE_ERROR)
-							}
+					blockLock.Lock()
+					delete(blockTasks, taskId)
+					blockLock.Unlock()
+				case COMMAND_SCATTER: //分发子任务, 等待结果汇总
+					timeout := BTASK_TIMEOUT
+					if ts, _ := strconv.Atoi(key); ts > 0 {
+						if ts > scatterMaxTimeout { //不能无限占用responser
+							ts = scatterMaxTimeout
+						}
+						timeout = time.Duration(ts) * time.Second
+					}
+					if id, r, err := w.scatter(timeout, cmd[2:]); err != nil {
+						w.Debug("scatter failed: %s", err)
+						node.SendMessage(client, "", RESPONSE_ERROR, err.Error())
+					} else {
+						w.Debug("scatter task %s result: %q", id, r)
+						node.SendMessage(client, "", RESPONSE_OK, id, r)
+					}
+				case COMMAND_COMPLETE: // 完成任务
+					if len(cmd) > 3 && cmd[2] != "" {
+						if w.finishTask(cmd[2], cmd[3]) {
+							node.SendMessage(client, "", RESPONSE_OK)
 						} else {
 							node.SendMessage(client, "", RESPONSE_ERROR)
 						}
 					} else {
 						node.SendMessage(client, "", RESPONSE_ERROR)
 					}
+				case COMMAND_RETRY: // 任务失败, 退避后重试
+					if len(cmd) > 2 && cmd[2] != "" {
+						reason := ""
+						if len(cmd) > 3 {
+							reason = cmd[3]
+						}
+						if err := w.retryTask(cmd[2], reason); err != nil {
+							node.SendMessage(client, "", RESPONSE_ERROR, err.Error())
+						} else {
+							node.SendMessage(client, "", RESPONSE_OK)
+						}
+					} else {
+						node.SendMessage(client, "", RESPONSE_ERROR)
+					}
+				case COMMAND_POLICY: // 设置/查看队列重试策略
+					if len(cmd) > 2 && cmd[2] != "" {
+						var p RetryPolicy
+						if err := json.Unmarshal([]byte(cmd[2]), &p); err != nil {
+							node.SendMessage(client, "", RESPONSE_ERROR, err.Error())
+						} else {
+							tasks.setPolicy(key, p)
+							node.SendMessage(client, "", RESPONSE_OK)
+						}
+					} else {
+						pj, _ := json.Marshal(tasks.policy(key))
+						node.SendMessage(client, "", RESPONSE_OK, string(pj))
+					}
+				case COMMAND_STATUS: // 任务状态
+					if r, err := w.taskStatus(key); err == ErrNil {
+						node.SendMessage(client, "", RESPONSE_NIL)
+					} else if err != nil {
+						node.SendMessage(client, "", RESPONSE_ERROR, err.Error())
+					} else {
+						node.SendMessage(client, "", RESPONSE_OK, r)
+					}
+				case COMMAND_WORKFLOW: // 提交工作流, 返回工作流id
+					if f, err := w.newWorkflow(key); err != nil {
+						w.Debug("workflow error: %s", err)
+						node.SendMessage(client, "", RESPONSE_ERROR, err.Error())
+					} else {
+						node.SendMessage(client, "", RESPONSE_OK, f.Id)
+					}
+				case COMMAND_WFSTATUS: // 工作流状态
+					if r, err := w.flowStatus(key); err == ErrNil {
+						node.SendMessage(client, "", RESPONSE_NIL)
+					} else if err != nil {
+						node.SendMessage(client, "", RESPONSE_ERROR, err.Error())
+					} else {
+						node.SendMessage(client, "", RESPONSE_OK, r)
+					}
 				case COMMAND_POP, COMMAND_BPOP: //pop或者阻塞式pop
 					bt := 0 * time.Second
 					if len(cmd) > 2 && act == COMMAND_BPOP {
@@ -155,6 +325,10 @@ func (w *OmqWorker) newResponser(i int) {
 					}
 					if value, err := mqpool.Pop(key, bt); err == nil {
 						w.Debug("pop %s: %s [%s]", key, value, time.Now())
+						var t *Task
+						if value, t = tasks.deliver(value); t != nil { //标记为已领取
+							w.saveTask(t)
+						}
 						node.SendMessage(client, "", RESPONSE_OK, value) //回复REQ,因此要加上一个空帧
 					} else if err.Error() == RESPONSE_NIL {
 						w.Trace("pop %s nil: %s", key, err)
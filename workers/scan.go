@@ -0,0 +1,145 @@
+package workers
+
+import (
+	"strconv"
+	"strings"
+)
+
+const (
+	_SCAN_COUNT = 100 //默认每页数量
+)
+
+/* {{{ func scanPattern(p string) string
+ * 没有通配符的当作前缀
+ */
+func scanPattern(p string) string {
+	if p == "" {
+		return "*"
+	}
+	if !strings.ContainsAny(p, "*?[") {
+		return p + "*"
+	}
+	return p
+}
+
+/* }}} */
+
+/* {{{ func internalKey(key string) bool
+ * omq内部使用的key(任务记录, 版本, 锁等)不列出
+ */
+func internalKey(key string) bool {
+	return strings.HasPrefix(key, "omq:") || strings.Contains(key, "}:omq:")
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) Scan() (r []string, err error)
+ * 按前缀/通配符遍历key, 参数: cursor, count
+ * 返回第一帧为下一个cursor("0"表示结束), 之后为key
+ */
+func (ls *LocalStorage) Scan() (r []string, err error) {
+	pattern := scanPattern(ls.key)
+	cursor := ls.value
+	if cursor == "" {
+		cursor = "0"
+	}
+	count := int64(_SCAN_COUNT)
+	if len(ls.args) > 1 {
+		if c, _ := strconv.ParseInt(ls.args[1], 10, 64); c > 0 {
+			count = c
+		}
+	}
+
+	var s Storage
+	if s, err = ls.storage(); err != nil {
+		return
+	}
+	var next string
+	var keys []string
+	if next, keys, err = s.Scan(pattern, cursor, count); err != nil {
+		return
+	}
+
+	r = make([]string, 0, len(keys)+1)
+	r = append(r, next)
+	for _, k := range keys {
+		if !internalKey(k) {
+			r = append(r, k)
+		}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func globMatch(pattern, s string) bool
+ * 与redis的KEYS/SCAN一致的通配符: * ? [abc] [a-z] [^a], \转义
+ */
+func globMatch(pattern, s string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case '*':
+			for len(pattern) > 0 && pattern[0] == '*' {
+				pattern = pattern[1:]
+			}
+			if len(pattern) == 0 {
+				return true
+			}
+			for i := 0; i <= len(s); i++ {
+				if globMatch(pattern, s[i:]) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if len(s) == 0 {
+				return false
+			}
+		case '[':
+			if len(s) == 0 {
+				return false
+			}
+			end := strings.IndexByte(pattern[1:], ']')
+			if end < 0 { //不完整的[当作普通字符
+				if s[0] != '[' {
+					return false
+				}
+				break
+			}
+			class := pattern[1 : end+1]
+			pattern = pattern[end+1:]
+			negate := len(class) > 0 && class[0] == '^'
+			if negate {
+				class = class[1:]
+			}
+			matched := false
+			for i := 0; i < len(class); i++ {
+				if i+2 < len(class) && class[i+1] == '-' {
+					if class[i] <= s[0] && s[0] <= class[i+2] {
+						matched = true
+					}
+					i += 2
+				} else if class[i] == s[0] {
+					matched = true
+				}
+			}
+			if matched == negate {
+				return false
+			}
+		case '\\':
+			if len(pattern) > 1 {
+				pattern = pattern[1:]
+			}
+			fallthrough
+		default:
+			if len(s) == 0 || s[0] != pattern[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		s = s[1:]
+	}
+	return len(s) == 0
+}
+
+/* }}} */
@@ -0,0 +1,97 @@
+package workers
+
+import (
+	"fmt"
+	"time"
+
+	ogoutils "github.com/Odinman/ogo/utils"
+)
+
+/* {{{ func (w *OmqWorker) scatter(timeout time.Duration, parts []string) (id string, reply []string, err error)
+ * 把子任务分发到多个队列(parts为queue,value成对), 等待所有结果或者超时
+ * reply按顺序每个子任务3帧: queue, 状态(OK/ERROR/NIL), 结果
+ */
+func (w *OmqWorker) scatter(timeout time.Duration, parts []string) (id string, reply []string, err error) {
+	if len(parts) == 0 || len(parts)%2 != 0 {
+		return "", nil, fmt.Errorf("parts should be queue,value pairs")
+	}
+	id = ogoutils.NewShortUUID()
+	n := len(parts) / 2
+	subs := make([]string, n)
+	results := make([]chan string, n)
+
+	blockLock.Lock()
+	for i := 0; i < n; i++ {
+		subs[i] = fmt.Sprint(id, ".", i)
+		results[i] = make(chan string, 1)
+		blockTasks[subs[i]] = results[i]
+	}
+	blockLock.Unlock()
+	defer func() {
+		blockLock.Lock()
+		for _, sub := range subs {
+			delete(blockTasks, sub)
+		}
+		blockLock.Unlock()
+	}()
+
+	pushed := make([]bool, n)
+	for i := 0; i < n; i++ {
+		queue := parts[i*2]
+		t, _ := newTask(subs[i], queue, "", []string{parts[i*2+1]})
+		t.Parent = id
+		if e := w.pushTask(t); e != nil {
+			w.Info("scatter %s push %s failed: %s", id, queue, e)
+		} else {
+			pushed[i] = true
+		}
+	}
+	w.Debug("scatter task %s to %d queues", id, n)
+
+	// 依次等待, 结果先到的会留在各自的channel里
+	deadline := time.After(timeout)
+	states := make([]string, n)
+	values := make([]string, n)
+	expired := false
+	for i := 0; i < n; i++ {
+		if !pushed[i] {
+			states[i] = RESPONSE_ERROR
+			continue
+		}
+		var r string
+		got := false
+		select {
+		case r = <-results[i]:
+			got = true
+		default:
+			if !expired {
+				select {
+				case r = <-results[i]:
+					got = true
+				case <-deadline:
+					expired = true
+				}
+			}
+		}
+		switch {
+		case !got:
+			states[i] = RESPONSE_NIL
+		case r == "0":
+			states[i] = RESPONSE_ERROR
+		default:
+			states[i] = RESPONSE_OK
+			values[i] = r
+		}
+	}
+
+	reply = make([]string, 0, n*3)
+	for i := 0; i < n; i++ {
+		if states[i] == RESPONSE_NIL { //未完成的子任务标记为超时
+			w.endTask(subs[i], TASK_TIMEOUT, "")
+		}
+		reply = append(reply, parts[i*2], states[i], values[i])
+	}
+	return
+}
+
+/* }}} */
@@ -0,0 +1,154 @@
+package workers
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+const (
+	_SCHEDULER_KEY        = "omq:scheduler"        //需要omq触发的定时key(hash), 值为scheduled
+	_SCHEDULER_LEADER_KEY = "omq:scheduler:leader" //leader锁
+)
+
+// 登记给scheduler的定时key, 以及所在存储(同时带有目标队列)
+type scheduled struct {
+	Key    string
+	Option StorageOption
+}
+
+/* {{{ func (ls *LocalStorage) register() error
+ * 带Queue选项的定时, 登记给scheduler, 到期后放入队列
+ * 统一登记在默认存储, leader从默认存储读取, 再到定时所在的存储领取
+ */
+func (ls *LocalStorage) register() error {
+	if ls.option == nil || ls.option.Queue == "" {
+		return nil
+	}
+	s, err := getStorage(nil)
+	if err != nil {
+		return err
+	}
+	e := &scheduled{Key: ls.key, Option: *ls.option}
+	field := ls.key
+	if e.Option.Type != "" || e.Option.Host != "" || e.Option.Port != "" || e.Option.Db != "" || e.Option.Table != "" {
+		// 不同存储中的同名key分别登记
+		field = fmt.Sprintf("%s@%s|%s|%s|%s|%s", ls.key, e.Option.Type, e.Option.Host, e.Option.Port, e.Option.Db, e.Option.Table)
+	}
+	v, _ := json.Marshal(e)
+	return s.HSet(_SCHEDULER_KEY, field, string(v))
+}
+
+/* }}} */
+
+/* {{{ func loadScheduled(s Storage) ([]*scheduled, error)
+ * 读取登记的定时, 兼容旧格式(field为key, 值为队列名)
+ */
+func loadScheduled(s Storage) ([]*scheduled, error) {
+	keys, err := s.HGetAll(_SCHEDULER_KEY)
+	if err != nil {
+		return nil, err
+	}
+	r := make([]*scheduled, 0, len(keys))
+	for field, v := range keys {
+		e := &scheduled{}
+		if json.Unmarshal([]byte(v), e) != nil || e.Key == "" {
+			e = &scheduled{Key: field, Option: StorageOption{Queue: v}}
+		}
+		r = append(r, e)
+	}
+	return r, nil
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) isLeader() (bool, error)
+ * 多个omq共用一个存储时, 只有leader触发定时
+ * 存储不支持锁(sql)时无法选举, 每个omq都触发(Claim领取后删除, 不会重复放入)
+ */
+func (w *OmqWorker) isLeader() (bool, error) {
+	s, err := getStorage(nil)
+	if err != nil {
+		return false, err
+	}
+	if _, err = s.Lock(_SCHEDULER_LEADER_KEY, nodeId, schedulerLockTTL); err == ErrNotSupported {
+		return true, err
+	} else if err != nil && err != ErrConflict {
+		w.Debug("leader lock failed: %s", err)
+	}
+	return err == nil, err
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) newScheduler()
+ * 把到期的定时内容作为普通任务放入对应队列
+ */
+func (w *OmqWorker) newScheduler() {
+	leader := false
+	for range time.Tick(time.Duration(schedulerInterval) * time.Second) {
+		s, err := getStorage(nil)
+		if err != nil {
+			continue
+		}
+		if l, err := w.isLeader(); l != leader {
+			if err == ErrNotSupported {
+				w.Info("storage %s can't lock, scheduler fires on every node", storageType)
+			} else {
+				w.Info("scheduler leader: %v", l)
+			}
+			leader = l
+		}
+		if !leader {
+			continue
+		}
+		entries, err := loadScheduled(s)
+		if err != nil {
+			w.Debug("get scheduler keys failed: %s", err)
+			continue
+		}
+		for _, e := range entries {
+			w.fire(e)
+		}
+	}
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) fire(e *scheduled)
+ * 从定时所在的存储领取所有到期内容, 放入队列
+ * 入队失败的按原来的时间放回, 下次再触发
+ */
+func (w *OmqWorker) fire(e *scheduled) {
+	ls := &LocalStorage{key: e.Key, option: &e.Option, batch: 100, withScores: true}
+	for {
+		r, err := ls.Timing()
+		if err != nil {
+			if err != ErrNil {
+				w.Debug("timing %s failed: %s", e.Key, err)
+			}
+			return
+		}
+		for i := 0; i+1 < len(r); i += 2 {
+			id, err := w.pushPlain(e.Option.Queue, []string{r[i]})
+			if err == nil {
+				w.Debug("fire %s to %s, task id: %s", e.Key, e.Option.Queue, id)
+				continue
+			}
+			w.Info("fire %s to %s failed: %s", e.Key, e.Option.Queue, err)
+			for ; i+1 < len(r); i += 2 {
+				ts, _ := strconv.Atoi(r[i+1])
+				if m, err := encodeValue(ls.option, r[i], true); err == nil {
+					ls.putBack(m, ts)
+				}
+			}
+			return
+		}
+		if len(r) < ls.batch*2 {
+			return
+		}
+	}
+}
+
+/* }}} */
@@ -0,0 +1,41 @@
+package workers
+
+import (
+	"testing"
+)
+
+func TestSchedulerRegister(t *testing.T) {
+	w := newTestWorker(t)
+	if _, err := w.localStorage([]string{COMMAND_SCHEDULE, `{"Queue":"q1"}`, "k", "m", "2000000000"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.localStorage([]string{COMMAND_SCHEDULE, `{"Queue":"q2","Type":"memory","Db":"1"}`, "k", "m", "2000000000"}); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := getStorage(nil)
+	if err := s.HSet(_SCHEDULER_KEY, "old", "q3"); err != nil { //旧格式
+		t.Fatal(err)
+	}
+
+	entries, err := loadScheduled(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	queues := make(map[string]*scheduled)
+	for _, e := range entries {
+		queues[e.Option.Queue] = e
+	}
+	if len(entries) != 3 || len(queues) != 3 {
+		t.Fatalf("scheduled %d entries, want 3", len(entries))
+	}
+	if e := queues["q1"]; e == nil || e.Key != "k" || e.Option.Db != "" {
+		t.Fatalf("default storage entry %+v", e)
+	}
+	// 记录定时所在的存储, leader到那里领取
+	if e := queues["q2"]; e == nil || e.Key != "k" || e.Option.Type != "memory" || e.Option.Db != "1" {
+		t.Fatalf("other storage entry %+v", e)
+	}
+	if e := queues["q3"]; e == nil || e.Key != "old" {
+		t.Fatalf("legacy entry %+v", e)
+	}
+}
@@ -0,0 +1,414 @@
+package workers
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/Odinman/goutils/zredis"
+)
+
+type sentinelStorage struct {
+	redisScripts
+	r *zredis.ZRedis
+}
+
+var (
+	sentinelStore *sentinelStorage //配置的redis, 只连接一次
+	sentinelLock  sync.Mutex
+)
+
+func init() {
+	RegisterStorage(_STORAGE_SENTINEL, openSentinel)
+}
+
+/* {{{ func openSentinel(opt *StorageOption) (Storage, error)
+ *
+ */
+func openSentinel(opt *StorageOption) (Storage, error) {
+	sentinelLock.Lock()
+	defer sentinelLock.Unlock()
+	if sentinelStore != nil {
+		return sentinelStore, nil
+	}
+	servers := strings.Split(redisAddr, ",")       //支持多个地址,逗号分隔
+	sentinels := strings.Split(redisSentinel, ",") //支持多个地址,逗号分隔
+	r, err := zredis.InitZRedis(servers, sentinels, redisPwd, redisDB, redisMTag)
+	if err != nil {
+		return nil, err
+	}
+	ss := &sentinelStorage{r: r}
+	ss.eval = ss.evalScript
+	sentinelStore = ss
+	return ss, nil
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) do(cmd string, args ...interface{}) (interface{}, error)
+ *
+ */
+func (ss *sentinelStorage) do(cmd string, args ...interface{}) (interface{}, error) {
+	redisConn := ss.r.Pool.Get()
+	defer redisConn.Close()
+	return redisConn.Do(cmd, args...)
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) evalScript(script string, keys []string, args ...string) (interface{}, error)
+ *
+ */
+func (ss *sentinelStorage) evalScript(script string, keys []string, args ...string) (interface{}, error) {
+	params := []interface{}{script, len(keys)}
+	for _, k := range keys {
+		params = append(params, k)
+	}
+	for _, a := range args {
+		params = append(params, a)
+	}
+	result, err := ss.do("EVAL", params...)
+	if err != nil {
+		return nil, err
+	} else if result == nil {
+		return nil, ErrNil
+	}
+	return normalizeReply(result), nil
+}
+
+/* }}} */
+
+/* {{{ func normalizeReply(reply interface{}) interface{}
+ * 把[]byte转为string, 与cluster的返回保持一致
+ */
+func normalizeReply(reply interface{}) interface{} {
+	switch rt := reply.(type) {
+	case []byte:
+		return string(rt)
+	case []interface{}:
+		for i, v := range rt {
+			rt[i] = normalizeReply(v)
+		}
+		return rt
+	default:
+		return reply
+	}
+}
+
+/* }}} */
+
+/* {{{ func keyArgs(key string, members []string) []interface{}
+ *
+ */
+func keyArgs(key string, members []string) []interface{} {
+	args := []interface{}{key}
+	for _, m := range members {
+		args = append(args, m)
+	}
+	return args
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) Get(key string) (string, error)
+ *
+ */
+func (ss *sentinelStorage) Get(key string) (string, error) {
+	result, err := ss.do("GET", key)
+	if err != nil {
+		return "", err
+	}
+	if b, _ := result.([]byte); len(b) > 0 {
+		return string(b), nil
+	}
+	return "", ErrNil
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) Del(key string) (err error)
+ * 版本号一起删除
+ */
+func (ss *sentinelStorage) Del(key string) (err error) {
+	_, err = ss.do("DEL", key, versionKey(key))
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) Schedule(key, member string, ts int) (err error)
+ *
+ */
+func (ss *sentinelStorage) Schedule(key, member string, ts int) (err error) {
+	_, err = ss.do("ZADD", key, ts, member)
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) Unschedule(key string, members ...string) (err error)
+ *
+ */
+func (ss *sentinelStorage) Unschedule(key string, members ...string) (err error) {
+	_, err = ss.do("ZREM", keyArgs(key, members)...)
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) Schedules(key, min, max string, count int) (members, scores []string, err error)
+ *
+ */
+func (ss *sentinelStorage) Schedules(key, min, max string, count int) (members, scores []string, err error) {
+	args := []interface{}{key, min, max, "WITHSCORES"}
+	if count > 0 {
+		args = append(args, "LIMIT", 0, count)
+	}
+	var result interface{}
+	if result, err = ss.do("ZRANGEBYSCORE", args...); err != nil {
+		return
+	}
+	rt, _ := result.([]interface{})
+	for i := 0; i+1 < len(rt); i += 2 {
+		members = append(members, string(rt[i].([]byte)))
+		scores = append(scores, string(rt[i+1].([]byte)))
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) Exists(key string) (bool, error)
+ *
+ */
+func (ss *sentinelStorage) Exists(key string) (bool, error) {
+	result, err := ss.do("EXISTS", key)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.(int64)
+	return n > 0, nil
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) TTL(key string) (int64, error)
+ *
+ */
+func (ss *sentinelStorage) TTL(key string) (int64, error) {
+	result, err := ss.do("TTL", key)
+	if err != nil {
+		return 0, err
+	}
+	ttl, _ := result.(int64)
+	if ttl == -2 {
+		return 0, ErrNil
+	}
+	return ttl, nil
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) Expire(key string, expire int) (err error)
+ * 版本号与值同时过期
+ */
+func (ss *sentinelStorage) Expire(key string, expire int) (err error) {
+	var ok bool
+	if ok, err = ss.expireKey(key, expire); err == nil && !ok {
+		err = ErrNil
+	} else if err == nil {
+		_, err = ss.expireKey(versionKey(key), expire)
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) expireKey(key string, expire int) (ok bool, err error)
+ * key不存在时ok为false
+ */
+func (ss *sentinelStorage) expireKey(key string, expire int) (ok bool, err error) {
+	if expire > 0 {
+		var result interface{}
+		result, err = ss.do("EXPIRE", key, expire)
+		n, _ := result.(int64)
+		return n > 0, err
+	}
+	if ok, err = ss.Exists(key); ok && err == nil {
+		_, err = ss.do("PERSIST", key)
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) MGet(keys ...string) (r []string, err error)
+ *
+ */
+func (ss *sentinelStorage) MGet(keys ...string) (r []string, err error) {
+	r = make([]string, len(keys))
+	args := make([]interface{}, len(keys))
+	for i, k := range keys {
+		args[i] = k
+	}
+	var result interface{}
+	if result, err = ss.do("MGET", args...); err != nil {
+		return nil, err
+	}
+	rt, _ := result.([]interface{})
+	for i, v := range rt {
+		if b, ok := v.([]byte); ok && i < len(r) {
+			r[i] = string(b)
+		}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) MSet(pairs ...string) (err error)
+ * 同一个实例, 用脚本一次写入
+ */
+func (ss *sentinelStorage) MSet(pairs ...string) (err error) {
+	keys := make([]string, 0, len(pairs))
+	values := make([]string, 0, len(pairs)/2)
+	for i := 0; i+1 < len(pairs); i += 2 {
+		keys = append(keys, pairs[i], versionKey(pairs[i]))
+		values = append(values, pairs[i+1])
+	}
+	_, err = ss.evalScript(_MSET_SCRIPT, keys, values...)
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) HGet(key, field string) (string, error)
+ *
+ */
+func (ss *sentinelStorage) HGet(key, field string) (string, error) {
+	result, err := ss.do("HGET", key, field)
+	if err != nil {
+		return "", err
+	} else if result == nil {
+		return "", ErrNil
+	}
+	return string(result.([]byte)), nil
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) HSet(key, field, value string) (err error)
+ *
+ */
+func (ss *sentinelStorage) HSet(key, field, value string) (err error) {
+	_, err = ss.do("HSET", key, field, value)
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) HDel(key string, fields ...string) (err error)
+ *
+ */
+func (ss *sentinelStorage) HDel(key string, fields ...string) (err error) {
+	_, err = ss.do("HDEL", keyArgs(key, fields)...)
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) HGetAll(key string) (map[string]string, error)
+ *
+ */
+func (ss *sentinelStorage) HGetAll(key string) (map[string]string, error) {
+	result, err := ss.do("HGETALL", key)
+	if err != nil {
+		return nil, err
+	}
+	m := make(map[string]string)
+	if rt, ok := result.([]interface{}); ok {
+		for i := 0; i+1 < len(rt); i += 2 {
+			m[string(rt[i].([]byte))] = string(rt[i+1].([]byte))
+		}
+	}
+	return m, nil
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) SAdd(key string, members ...string) (err error)
+ *
+ */
+func (ss *sentinelStorage) SAdd(key string, members ...string) (err error) {
+	_, err = ss.do("SADD", keyArgs(key, members)...)
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) SRem(key string, members ...string) (err error)
+ *
+ */
+func (ss *sentinelStorage) SRem(key string, members ...string) (err error) {
+	_, err = ss.do("SREM", keyArgs(key, members)...)
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) SMembers(key string) (members []string, err error)
+ *
+ */
+func (ss *sentinelStorage) SMembers(key string) (members []string, err error) {
+	var result interface{}
+	if result, err = ss.do("SMEMBERS", key); err != nil {
+		return nil, err
+	}
+	rt, _ := result.([]interface{})
+	members = make([]string, 0, len(rt))
+	for _, v := range rt {
+		members = append(members, string(v.([]byte)))
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) SIsMember(key, member string) (bool, error)
+ *
+ */
+func (ss *sentinelStorage) SIsMember(key, member string) (bool, error) {
+	result, err := ss.do("SISMEMBER", key, member)
+	if err != nil {
+		return false, err
+	}
+	n, _ := result.(int64)
+	return n > 0, nil
+}
+
+/* }}} */
+
+/* {{{ func (ss *sentinelStorage) Scan(pattern, cursor string, count int64) (next string, keys []string, err error)
+ *
+ */
+func (ss *sentinelStorage) Scan(pattern, cursor string, count int64) (next string, keys []string, err error) {
+	var result interface{}
+	if result, err = ss.do("SCAN", cursor, "MATCH", pattern, "COUNT", count); err != nil {
+		return
+	}
+	rt, _ := result.([]interface{})
+	if len(rt) != 2 {
+		return "", nil, fmt.Errorf("unexpected scan reply")
+	}
+	nb, _ := rt[0].([]byte)
+	next = string(nb)
+	ks, _ := rt[1].([]interface{})
+	for _, k := range ks {
+		if b, ok := k.([]byte); ok {
+			keys = append(keys, string(b))
+		}
+	}
+	return
+}
+
+/* }}} */
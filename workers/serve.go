@@ -79,6 +79,11 @@ func (w *OmqWorker) serve() {
 	defer backend.Close()
 	backend.Bind("inproc://backend")
 
+	// 异步回复(阻塞加锁等)
+	w.connectReplier()
+	defer replier.Close()
+	go w.newLockWaiter()
+
 	//  可用节点列表,LRU算法,最空的节点保持在队列最前
 	nodes := make([]Node, 0)
 
@@ -127,7 +132,9 @@ func (w *OmqWorker) serve() {
 
 				//  Any sign of life from worker means it's ready
 				identity, msg := utils.Unwrap(msg)
-				nodes = nodeReady(newNode(identity), nodes)
+				if identity != _REPLIER_IDENTITY { //异步回复不是节点
+					nodes = nodeReady(newNode(identity), nodes)
+				}
 
 				//  Validate control message, or return reply to client
 				if len(msg) == 1 {
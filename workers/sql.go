@@ -0,0 +1,271 @@
+package workers
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sync"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+)
+
+/*
+ * database/sql存储: key-value表(k, v, ver, expire_at)以及定时表(k, member, ts)
+ * 表名来自StorageOption.Table(默认配置sql_table), 定时表为表名加"_schedule"
+ * mysql的StorageOption.Db为库名; 过期的内容读取时忽略, 并定期清理
+ */
+
+var (
+	sqlIdent = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+	// 建表语句, 参数: kv表, 定时表, 索引前缀
+	// mysql的key以及定时内容可能是二进制(编码后), 用VARBINARY(区分大小写, 与redis一致)
+	// 主键总长度不能超过innodb的3072字节: key最长1024, 定时内容最长2048
+	sqlDDL = map[string][]string{
+		_STORAGE_MYSQL: {
+			`CREATE TABLE IF NOT EXISTS %[1]s (k VARBINARY(1024) NOT NULL PRIMARY KEY, v LONGBLOB, ver BIGINT NOT NULL DEFAULT 0, expire_at BIGINT NOT NULL DEFAULT 0, KEY (expire_at))`,
+			`CREATE TABLE IF NOT EXISTS %[2]s (k VARBINARY(1024) NOT NULL, member VARBINARY(2048) NOT NULL, ts BIGINT NOT NULL, PRIMARY KEY (k, member), KEY (k, ts))`,
+		},
+		_STORAGE_SQLITE: {
+			`CREATE TABLE IF NOT EXISTS %[1]s (k TEXT NOT NULL PRIMARY KEY, v BLOB, ver INTEGER NOT NULL DEFAULT 0, expire_at INTEGER NOT NULL DEFAULT 0)`,
+			`CREATE INDEX IF NOT EXISTS %[3]s_expire ON %[1]s (expire_at)`,
+			`CREATE TABLE IF NOT EXISTS %[2]s (k TEXT NOT NULL, member TEXT NOT NULL, ts INTEGER NOT NULL, PRIMARY KEY (k, member))`,
+			`CREATE INDEX IF NOT EXISTS %[3]s_schedule_ts ON %[2]s (k, ts)`,
+		},
+	}
+
+	// 写入(存在则更新), 参数: 表名; kv的参数为k, v, expire_at, now(已过期的版本号从1开始)
+	sqlUpsert = map[string][2]string{
+		_STORAGE_MYSQL: {
+			`INSERT INTO %s (k, v, ver, expire_at) VALUES (?, ?, 1, ?) ON DUPLICATE KEY UPDATE ver = IF(expire_at > 0 AND expire_at <= ?, 1, ver + 1), v = VALUES(v), expire_at = VALUES(expire_at)`,
+			`INSERT INTO %s (k, member, ts) VALUES (?, ?, ?) ON DUPLICATE KEY UPDATE ts = VALUES(ts)`,
+		},
+		_STORAGE_SQLITE: {
+			`INSERT INTO %s (k, v, ver, expire_at) VALUES (?, ?, 1, ?) ON CONFLICT (k) DO UPDATE SET v = excluded.v, ver = CASE WHEN expire_at > 0 AND expire_at <= ? THEN 1 ELSE ver + 1 END, expire_at = excluded.expire_at`,
+			`INSERT INTO %s (k, member, ts) VALUES (?, ?, ?) ON CONFLICT (k, member) DO UPDATE SET ts = excluded.ts`,
+		},
+	}
+
+	sqlDBs     = make(map[string]*sqlDB) //driver+dsn => 连接池
+	sqlDBsLock sync.Mutex
+)
+
+type sqlDB struct {
+	db     *sql.DB
+	driver string
+
+	lock   sync.Mutex
+	tables map[string]bool //已建的kv表, 定期清理过期内容
+}
+
+type sqlStorage struct {
+	unsupportedStorage
+	d     *sqlDB
+	table string //kv表
+	sched string //定时表
+}
+
+func init() {
+	RegisterStorage(_STORAGE_MYSQL, openMySQL)
+}
+
+/* {{{ func openMySQL(opt *StorageOption) (Storage, error)
+ *
+ */
+func openMySQL(opt *StorageOption) (Storage, error) {
+	return openSQL(_STORAGE_MYSQL, sqlDSN, opt)
+}
+
+/* }}} */
+
+/* {{{ func openSQL(driver, dsn string, opt *StorageOption) (Storage, error)
+ * 同一个dsn共用连接池, 每个表一个Storage
+ */
+func openSQL(driver, dsn string, opt *StorageOption) (Storage, error) {
+	if dsn == "" {
+		return nil, fmt.Errorf("%s dsn not configured", driver)
+	}
+	table := sqlTable
+	if opt.Table != "" {
+		table = opt.Table
+	}
+	if !sqlIdent.MatchString(table) {
+		return nil, fmt.Errorf("invalid table: %s", table)
+	}
+	ss := &sqlStorage{table: table, sched: table + "_schedule"}
+	if opt.Db != "" && driver == _STORAGE_MYSQL {
+		if !sqlIdent.MatchString(opt.Db) {
+			return nil, fmt.Errorf("invalid db: %s", opt.Db)
+		}
+		ss.table = opt.Db + "." + ss.table
+		ss.sched = opt.Db + "." + ss.sched
+	}
+	// 表会自动创建, 客户端只能使用配置的表
+	if ss.table != sqlTable && !sqlTables[ss.table] {
+		return nil, fmt.Errorf("table not allowed: %s", ss.table)
+	}
+
+	var err error
+	if ss.d, err = sqlConnect(driver, dsn); err != nil {
+		return nil, err
+	}
+	for _, ddl := range sqlDDL[driver] {
+		if _, err = ss.d.db.Exec(fmt.Sprintf(ddl, ss.table, ss.sched, table)); err != nil {
+			return nil, err
+		}
+	}
+	ss.d.lock.Lock()
+	ss.d.tables[ss.table] = true
+	ss.d.lock.Unlock()
+	return ss, nil
+}
+
+/* }}} */
+
+/* {{{ func sqlConnect(driver, dsn string) (*sqlDB, error)
+ * 连接池, 同时启动过期清理
+ */
+func sqlConnect(driver, dsn string) (*sqlDB, error) {
+	sqlDBsLock.Lock()
+	defer sqlDBsLock.Unlock()
+	if d, ok := sqlDBs[driver+dsn]; ok {
+		return d, nil
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if driver == _STORAGE_SQLITE { //sqlite同时只能有一个写入
+		db.SetMaxOpenConns(1)
+	} else {
+		db.SetMaxOpenConns(sqlMaxOpen)
+	}
+	db.SetMaxIdleConns(sqlMaxIdle)
+	if sqlConnLifetime > 0 {
+		db.SetConnMaxLifetime(time.Duration(sqlConnLifetime) * time.Second)
+	}
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, err
+	}
+	d := &sqlDB{db: db, driver: driver, tables: make(map[string]bool)}
+	sqlDBs[driver+dsn] = d
+	go d.purge()
+	return d, nil
+}
+
+/* }}} */
+
+/* {{{ func (d *sqlDB) purge()
+ * 定期删除过期内容
+ */
+func (d *sqlDB) purge() {
+	for now := range time.Tick(time.Duration(sqlPurgeInterval) * time.Second) {
+		d.lock.Lock()
+		tables := make([]string, 0, len(d.tables))
+		for t := range d.tables {
+			tables = append(tables, t)
+		}
+		d.lock.Unlock()
+		for _, t := range tables {
+			d.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE expire_at > 0 AND expire_at <= ?", t), now.Unix())
+		}
+	}
+}
+
+/* }}} */
+
+/* {{{ func (ss *sqlStorage) Get(key string) (string, error)
+ *
+ */
+func (ss *sqlStorage) Get(key string) (string, error) {
+	var v []byte
+	err := ss.d.db.QueryRow(fmt.Sprintf("SELECT v FROM %s WHERE k = ? AND (expire_at = 0 OR expire_at > ?)", ss.table), key, time.Now().Unix()).Scan(&v)
+	if err == sql.ErrNoRows {
+		return "", ErrNil
+	} else if err != nil {
+		return "", err
+	}
+	return string(v), nil
+}
+
+/* }}} */
+
+/* {{{ func (ss *sqlStorage) Set(key, value string, expire int) (err error)
+ * 与redis一样维护版本号: 不存在(或已过期)时为1, 每次写入加1; 单条语句完成, 并发写入不冲突
+ */
+func (ss *sqlStorage) Set(key, value string, expire int) (err error) {
+	now := time.Now().Unix()
+	expireAt := int64(0)
+	if expire > 0 {
+		expireAt = now + int64(expire)
+	}
+	_, err = ss.d.db.Exec(fmt.Sprintf(sqlUpsert[ss.d.driver][0], ss.table), key, []byte(value), expireAt, now)
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ss *sqlStorage) Del(key string) (err error)
+ *
+ */
+func (ss *sqlStorage) Del(key string) (err error) {
+	_, err = ss.d.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE k = ?", ss.table), key)
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ss *sqlStorage) Schedule(key, member string, ts int) (err error)
+ * 已经存在的修改时间
+ */
+func (ss *sqlStorage) Schedule(key, member string, ts int) (err error) {
+	_, err = ss.d.db.Exec(fmt.Sprintf(sqlUpsert[ss.d.driver][1], ss.sched), key, member, ts)
+	return
+}
+
+/* }}} */
+
+/* {{{ func (ss *sqlStorage) Claim(key string, now int64, batch int) (members, scores []string, err error)
+ * 逐个删除, 只有删除成功的才算领取到, 多个omq同时领取时每项只被领取一次
+ */
+func (ss *sqlStorage) Claim(key string, now int64, batch int) (members, scores []string, err error) {
+	var rows *sql.Rows
+	if rows, err = ss.d.db.Query(fmt.Sprintf("SELECT member, ts FROM %s WHERE k = ? AND ts <= ? ORDER BY ts LIMIT %d", ss.sched, batch), key, now); err != nil {
+		return
+	}
+	var ms []string
+	var ts []int64
+	for rows.Next() {
+		var m string
+		var t int64
+		if err = rows.Scan(&m, &t); err != nil {
+			rows.Close()
+			return
+		}
+		ms = append(ms, m)
+		ts = append(ts, t)
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return
+	}
+
+	for i, m := range ms {
+		var res sql.Result
+		if res, err = ss.d.db.Exec(fmt.Sprintf("DELETE FROM %s WHERE k = ? AND member = ? AND ts = ?", ss.sched), key, m, ts[i]); err != nil {
+			if len(members) > 0 { //已经领取的要返回
+				err = nil
+			}
+			return
+		}
+		if n, _ := res.RowsAffected(); n > 0 {
+			members = append(members, m)
+			scores = append(scores, fmt.Sprint(ts[i]))
+		}
+	}
+	return
+}
+
+/* }}} */
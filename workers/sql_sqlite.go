@@ -0,0 +1,25 @@
+//go:build cgo
+// +build cgo
+
+package workers
+
+import (
+	_ "github.com/mattn/go-sqlite3"
+)
+
+/*
+ * sqlite需要cgo, 适合单机以及测试
+ */
+
+func init() {
+	RegisterStorage(_STORAGE_SQLITE, openSQLite)
+}
+
+/* {{{ func openSQLite(opt *StorageOption) (Storage, error)
+ * Db/Host等选项无效, 数据库文件为配置sqlite_path
+ */
+func openSQLite(opt *StorageOption) (Storage, error) {
+	return openSQL(_STORAGE_SQLITE, sqlitePath, opt)
+}
+
+/* }}} */
@@ -0,0 +1,134 @@
+//go:build cgo
+// +build cgo
+
+package workers
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+)
+
+func openTestSQLite(t *testing.T, opt *StorageOption) (*sqlStorage, error) {
+	sqlitePath = filepath.Join(t.TempDir(), "omq.db")
+	sqlTable = "omq_kv"
+	sqlMaxIdle = 1
+	if sqlPurgeInterval == 0 { //清理goroutine会读取
+		sqlPurgeInterval = 60
+	}
+	s, err := openSQLite(opt)
+	if err != nil {
+		return nil, err
+	}
+	ss := s.(*sqlStorage)
+	t.Cleanup(func() {
+		sqlDBsLock.Lock()
+		delete(sqlDBs, _STORAGE_SQLITE+sqlitePath)
+		sqlDBsLock.Unlock()
+		ss.d.db.Close()
+	})
+	return ss, nil
+}
+
+func sqlVersion(t *testing.T, ss *sqlStorage, key string) int64 {
+	var ver int64
+	if err := ss.d.db.QueryRow("SELECT ver FROM "+ss.table+" WHERE k = ?", key).Scan(&ver); err != nil {
+		t.Fatal(err)
+	}
+	return ver
+}
+
+func TestSQLiteSetVersion(t *testing.T) {
+	ss, err := openTestSQLite(t, &StorageOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 1; i <= 3; i++ {
+		if err := ss.Set("k", "v", 0); err != nil {
+			t.Fatal(err)
+		}
+		if ver := sqlVersion(t, ss, "k"); ver != int64(i) {
+			t.Fatalf("version %d, want %d", ver, i)
+		}
+	}
+	if v, err := ss.Get("k"); err != nil || v != "v" {
+		t.Fatalf("get %q, %v", v, err)
+	}
+
+	// 已过期的重新从1开始
+	if _, err := ss.d.db.Exec("UPDATE "+ss.table+" SET expire_at = ? WHERE k = ?", time.Now().Unix()-1, "k"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ss.Get("k"); err != ErrNil {
+		t.Fatalf("expired get: %v", err)
+	}
+	if err := ss.Set("k", "v2", 10); err != nil {
+		t.Fatal(err)
+	}
+	if ver := sqlVersion(t, ss, "k"); ver != 1 {
+		t.Fatalf("version after expire %d, want 1", ver)
+	}
+}
+
+func TestSQLiteConcurrentSet(t *testing.T) {
+	ss, err := openTestSQLite(t, &StorageOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	const n = 20
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			errs <- ss.Set("race", "v", 0)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ver := sqlVersion(t, ss, "race"); ver != n {
+		t.Fatalf("version %d, want %d", ver, n)
+	}
+}
+
+func TestSQLiteSchedule(t *testing.T) {
+	ss, err := openTestSQLite(t, &StorageOption{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().Unix()
+	if err := ss.Schedule("q", "a", int(now+100)); err != nil {
+		t.Fatal(err)
+	}
+	if err := ss.Schedule("q", "a", int(now-1)); err != nil { //已存在的修改时间
+		t.Fatal(err)
+	}
+	members, _, err := ss.Claim("q", now, 10)
+	if err != nil || len(members) != 1 || members[0] != "a" {
+		t.Fatalf("claim %q, %v", members, err)
+	}
+	if members, _, _ = ss.Claim("q", now, 10); len(members) != 0 {
+		t.Fatalf("claimed twice: %q", members)
+	}
+}
+
+func TestSQLiteTableAllowed(t *testing.T) {
+	sqlTables = map[string]bool{"omq_other": true}
+	defer func() { sqlTables = nil }()
+	if _, err := openTestSQLite(t, &StorageOption{Table: "omq_evil"}); err == nil {
+		t.Fatal("table not in sql_tables opened")
+	}
+	if _, err := openTestSQLite(t, &StorageOption{Table: "x; DROP TABLE omq_kv"}); err == nil {
+		t.Fatal("invalid table opened")
+	}
+	if _, err := openTestSQLite(t, &StorageOption{Table: "omq_other"}); err != nil {
+		t.Fatal(err)
+	}
+}
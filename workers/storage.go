@@ -0,0 +1,340 @@
+package workers
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+/*
+ * 存储后端: 由StorageOption.Type选择, 为空使用默认存储(配置storage)
+ * 新的后端实现Storage接口, 在init()中RegisterStorage即可
+ */
+type Storage interface {
+	// 基本
+	Get(key string) (string, error) //不存在返回ErrNil
+	Set(key, value string, expire int) error
+	Del(key string) error
+	Schedule(key, member string, ts int) error
+	Claim(key string, now int64, batch int) (members, scores []string, err error) //领取并删除到期内容
+
+	// 定时管理
+	Unschedule(key string, members ...string) error
+	Reschedule(key, member string, ts int) error //不存在返回ErrNil
+	Schedules(key, min, max string, count int) (members, scores []string, err error)
+
+	// key-value扩展
+	Exists(key string) (bool, error)
+	TTL(key string) (int64, error)       //没有过期时间返回-1, 不存在返回ErrNil
+	Expire(key string, expire int) error //expire<=0去掉过期时间, 不存在返回ErrNil
+	Incr(key string, delta int64) (int64, error)
+	MGet(keys ...string) ([]string, error) //不存在的为空
+	MSet(pairs ...string) error
+	GetV(key string) (value string, ver int64, err error)
+	CAS(key, value, mode, expected string, expire int) (int64, error) //条件不满足返回ErrConflict
+
+	// hash
+	HGet(key, field string) (string, error)
+	HSet(key, field, value string) error
+	HDel(key string, fields ...string) error
+	HGetAll(key string) (map[string]string, error)
+
+	// set
+	SAdd(key string, members ...string) error
+	SRem(key string, members ...string) error
+	SMembers(key string) ([]string, error)
+	SIsMember(key, member string) (bool, error)
+
+	// 锁
+	Lock(key, owner string, ttl int) (int64, error) //被其他owner持有返回ErrConflict
+	Unlock(key, owner string) error                 //未锁返回ErrNil
+	Renew(key, owner string, ttl int) (int64, error)
+
+	// 遍历
+	Scan(pattern, cursor string, count int64) (next string, keys []string, err error)
+}
+
+// 创建存储, opt.Type已确定; 同样的连接参数只创建一次
+type StorageCreator func(opt *StorageOption) (Storage, error)
+
+var (
+	ErrNotSupported = errors.New("not supported")
+	ErrWrongType    = errors.New("wrong kind of value") //key已经存在, 但是类型不对
+
+	creators     = make(map[string]StorageCreator)
+	storages     = make(map[string]Storage) //已连接的存储
+	storagesLock sync.Mutex
+)
+
+// 连接失败的存储在backoff期间直接返回上次的错误, 不再同步重连
+type connectFailure struct {
+	err     error
+	until   time.Time
+	backoff time.Duration
+}
+
+const (
+	_CONNECT_BACKOFF     = time.Second
+	_CONNECT_BACKOFF_MAX = 30 * time.Second
+)
+
+var connectFailures = make(map[string]*connectFailure) //storageKey => 最近的连接失败, 由storagesLock保护
+
+/* {{{ func RegisterStorage(name string, creator StorageCreator)
+ * 注册存储后端
+ */
+func RegisterStorage(name string, creator StorageCreator) {
+	storagesLock.Lock()
+	defer storagesLock.Unlock()
+	creators[name] = creator
+}
+
+/* }}} */
+
+/* {{{ func storageKey(opt *StorageOption) string
+ * 连接参数相同的共用一个存储
+ */
+func storageKey(opt *StorageOption) string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%s", opt.Type, opt.Host, opt.Port, opt.Pwd, opt.Db, opt.Table)
+}
+
+/* }}} */
+
+/* {{{ func getStorage(option *StorageOption) (Storage, error)
+ * 获取存储后端, 第一次使用时连接, 连接失败的backoff之后再试; option为nil使用默认存储
+ */
+func getStorage(option *StorageOption) (Storage, error) {
+	opt := StorageOption{}
+	if option != nil {
+		opt = *option
+		opt.Queue = ""
+	}
+	if opt.Type == "" {
+		opt.Type = storageType
+	}
+	key := storageKey(&opt)
+	if opt.Type == _STORAGE_REDIS && !isDefaultRedis(&opt) { //其他redis由openTarget缓存(有上限)
+		if err := connectBackoff(key); err != nil {
+			return nil, err
+		}
+		s, err := openTarget(&opt)
+		if err != nil {
+			err = fmt.Errorf("can't reach localstorage(%s): %w", opt.Type, err)
+		}
+		connectResult(key, err)
+		return s, err
+	}
+	storagesLock.Lock()
+	s, ok := storages[key]
+	creator, found := creators[opt.Type]
+	full := len(storages) >= storagePool
+	storagesLock.Unlock()
+	if ok {
+		return s, nil
+	} else if !found {
+		return nil, fmt.Errorf("storage not supported: %s", opt.Type)
+	} else if full && option != nil { //后端持有连接(sql连接池等), 不能淘汰, 超过上限的不再连接
+		return nil, fmt.Errorf("too many storages, storage_pools is %d", storagePool)
+	}
+
+	// 连接时不持有锁
+	if err := connectBackoff(key); err != nil {
+		return nil, err
+	}
+	s, err := creator(&opt)
+	if err != nil {
+		err = fmt.Errorf("can't reach localstorage(%s): %w", opt.Type, err)
+	}
+	connectResult(key, err)
+	if err != nil {
+		return nil, err
+	}
+	storagesLock.Lock()
+	defer storagesLock.Unlock()
+	if cur, ok := storages[key]; ok { //已经被其他goroutine连接
+		return cur, nil
+	}
+	storages[key] = s
+	return s, nil
+}
+
+/* }}} */
+
+/* {{{ func connectBackoff(key string) error
+ * 上次连接失败且还在backoff期间的, 返回上次的错误
+ */
+func connectBackoff(key string) error {
+	storagesLock.Lock()
+	defer storagesLock.Unlock()
+	if f, ok := connectFailures[key]; ok && time.Now().Before(f.until) {
+		return f.err
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func connectResult(key string, err error)
+ * 记录连接结果, 连续失败时backoff加倍(最多_CONNECT_BACKOFF_MAX)
+ */
+func connectResult(key string, err error) {
+	storagesLock.Lock()
+	defer storagesLock.Unlock()
+	if err == nil {
+		delete(connectFailures, key)
+		return
+	}
+	f, ok := connectFailures[key]
+	if !ok {
+		if len(connectFailures) >= storagePool { //客户端可以指定任意连接参数, 限制记录数
+			for k := range connectFailures {
+				delete(connectFailures, k)
+				break
+			}
+		}
+		f = &connectFailure{backoff: _CONNECT_BACKOFF}
+		connectFailures[key] = f
+	} else if f.backoff *= 2; f.backoff > _CONNECT_BACKOFF_MAX {
+		f.backoff = _CONNECT_BACKOFF_MAX
+	}
+	f.err, f.until = err, time.Now().Add(f.backoff)
+}
+
+/* }}} */
+
+/* {{{ func storageReady() bool
+ * 默认存储是否可用
+ */
+func storageReady() bool {
+	_, err := getStorage(nil)
+	return err == nil
+}
+
+/* }}} */
+
+/* {{{ func (ls *LocalStorage) storage() (Storage, error)
+ *
+ */
+func (ls *LocalStorage) storage() (Storage, error) {
+	return getStorage(ls.option)
+}
+
+/* }}} */
+
+/*
+ * 只实现部分操作的后端可以嵌入unsupportedStorage, 其余操作返回ErrNotSupported
+ */
+type unsupportedStorage struct{}
+
+func (unsupportedStorage) Get(key string) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (unsupportedStorage) Set(key, value string, expire int) error {
+	return ErrNotSupported
+}
+
+func (unsupportedStorage) Del(key string) error {
+	return ErrNotSupported
+}
+
+func (unsupportedStorage) Schedule(key, member string, ts int) error {
+	return ErrNotSupported
+}
+
+func (unsupportedStorage) Claim(key string, now int64, batch int) ([]string, []string, error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (unsupportedStorage) Unschedule(key string, members ...string) error {
+	return ErrNotSupported
+}
+
+func (unsupportedStorage) Reschedule(key, member string, ts int) error {
+	return ErrNotSupported
+}
+
+func (unsupportedStorage) Schedules(key, min, max string, count int) ([]string, []string, error) {
+	return nil, nil, ErrNotSupported
+}
+
+func (unsupportedStorage) Exists(key string) (bool, error) {
+	return false, ErrNotSupported
+}
+
+func (unsupportedStorage) TTL(key string) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (unsupportedStorage) Expire(key string, expire int) error {
+	return ErrNotSupported
+}
+
+func (unsupportedStorage) Incr(key string, delta int64) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (unsupportedStorage) MGet(keys ...string) ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+func (unsupportedStorage) MSet(pairs ...string) error {
+	return ErrNotSupported
+}
+
+func (unsupportedStorage) GetV(key string) (string, int64, error) {
+	return "", 0, ErrNotSupported
+}
+
+func (unsupportedStorage) CAS(key, value, mode, expected string, expire int) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (unsupportedStorage) HGet(key, field string) (string, error) {
+	return "", ErrNotSupported
+}
+
+func (unsupportedStorage) HSet(key, field, value string) error {
+	return ErrNotSupported
+}
+
+func (unsupportedStorage) HDel(key string, fields ...string) error {
+	return ErrNotSupported
+}
+
+func (unsupportedStorage) HGetAll(key string) (map[string]string, error) {
+	return nil, ErrNotSupported
+}
+
+func (unsupportedStorage) SAdd(key string, members ...string) error {
+	return ErrNotSupported
+}
+
+func (unsupportedStorage) SRem(key string, members ...string) error {
+	return ErrNotSupported
+}
+
+func (unsupportedStorage) SMembers(key string) ([]string, error) {
+	return nil, ErrNotSupported
+}
+
+func (unsupportedStorage) SIsMember(key, member string) (bool, error) {
+	return false, ErrNotSupported
+}
+
+func (unsupportedStorage) Lock(key, owner string, ttl int) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (unsupportedStorage) Unlock(key, owner string) error {
+	return ErrNotSupported
+}
+
+func (unsupportedStorage) Renew(key, owner string, ttl int) (int64, error) {
+	return 0, ErrNotSupported
+}
+
+func (unsupportedStorage) Scan(pattern, cursor string, count int64) (string, []string, error) {
+	return "", nil, ErrNotSupported
+}
@@ -0,0 +1,85 @@
+package workers
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestConnectBackoff(t *testing.T) {
+	newTestWorker(t)
+	calls := 0
+	down := errors.New("connection refused")
+	RegisterStorage("flaky", func(opt *StorageOption) (Storage, error) {
+		calls++
+		if calls < 3 {
+			return nil, down
+		}
+		return newMemStorage(), nil
+	})
+	opt := &StorageOption{Type: "flaky"}
+
+	// backoff期间不再重连, 返回上次的错误
+	for i := 0; i < 3; i++ {
+		if _, err := getStorage(opt); !errors.Is(err, down) {
+			t.Fatalf("get %d: %v", i, err)
+		}
+	}
+	if calls != 1 {
+		t.Fatalf("connect called %d times during backoff", calls)
+	}
+
+	key := storageKey(opt)
+	expire := func() {
+		storagesLock.Lock()
+		connectFailures[key].until = time.Now()
+		storagesLock.Unlock()
+	}
+	expire()
+	getStorage(opt)
+	storagesLock.Lock()
+	backoff := connectFailures[key].backoff
+	storagesLock.Unlock()
+	if calls != 2 || backoff != 2*_CONNECT_BACKOFF {
+		t.Fatalf("calls %d, backoff %s", calls, backoff)
+	}
+
+	expire()
+	if _, err := getStorage(opt); err != nil {
+		t.Fatal(err)
+	}
+	storagesLock.Lock()
+	_, failed := connectFailures[key]
+	storagesLock.Unlock()
+	if failed {
+		t.Fatal("failure kept after connect")
+	}
+}
+
+func TestStoragePool(t *testing.T) {
+	newTestWorker(t)
+	storagePool = 2
+	opened := 0
+	RegisterStorage("counted", func(opt *StorageOption) (Storage, error) {
+		opened++
+		return newMemStorage(), nil
+	})
+	for _, db := range []string{"1", "2", "1"} {
+		if _, err := getStorage(&StorageOption{Type: "counted", Db: db}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// 已连接的不淘汰(不会重新连接), 超过上限的拒绝
+	if _, err := getStorage(&StorageOption{Type: "counted", Db: "3"}); err == nil {
+		t.Fatal("storage over storage_pools connected")
+	}
+	if _, err := getStorage(&StorageOption{Type: "counted", Db: "2"}); err != nil {
+		t.Fatal(err)
+	}
+	if opened != 2 {
+		t.Fatalf("opened %d times, want 2", opened)
+	}
+	if _, err := getStorage(nil); err != nil { //默认存储不受限制
+		t.Fatal(err)
+	}
+}
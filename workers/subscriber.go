@@ -1,18 +1,91 @@
 package workers
 
 import (
+	"encoding/json"
 	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"sync"
 	"time"
 
 	"github.com/Odinman/omq/utils"
 	zmq "github.com/pebbe/zmq4"
 )
 
-/* {{{ func connectPub() (*zmq.Socket, *zmq.Poller)
+// 订阅的其他机房状态
+type peerStatus struct {
+	Addr       string
+	Connected  bool  // 心跳正常
+	Received   int   // 收到的命令数
+	LastSeen   int64 // 最后收到消息(包括心跳)的时间
+	Reconnects int
+
+	lock sync.Mutex
+}
+
+var (
+	peers     = make(map[string]*peerStatus)
+	peersLock sync.Mutex
+)
+
+/* {{{ func newPeerStatus(peer string) *peerStatus
+ *
+ */
+func newPeerStatus(peer string) *peerStatus {
+	peersLock.Lock()
+	defer peersLock.Unlock()
+	ps := &peerStatus{Addr: peer}
+	peers[peer] = ps
+	return ps
+}
+
+/* }}} */
+
+/* {{{ func (ps *peerStatus) update(f func(ps *peerStatus))
+ *
+ */
+func (ps *peerStatus) update(f func(ps *peerStatus)) {
+	ps.lock.Lock()
+	defer ps.lock.Unlock()
+	f(ps)
+}
+
+/* }}} */
+
+/* {{{ func peersStatus() []string
+ * 每个peer一帧json, 按地址排序
+ */
+func peersStatus() []string {
+	peersLock.Lock()
+	addrs := make([]string, 0, len(peers))
+	for addr := range peers {
+		addrs = append(addrs, addr)
+	}
+	peersLock.Unlock()
+	sort.Strings(addrs)
+
+	r := make([]string, 0, len(addrs))
+	for _, addr := range addrs {
+		peersLock.Lock()
+		ps := peers[addr]
+		peersLock.Unlock()
+		ps.lock.Lock()
+		pj, _ := json.Marshal(ps)
+		ps.lock.Unlock()
+		r = append(r, string(pj))
+	}
+	return r
+}
+
+/* }}} */
+
+/* {{{ func connectPub(peer string) (*zmq.Socket, *zmq.Poller)
  *  Helper function that returns a new configured socket
  *  connected to the Paranoid Pirate queue
+ *  peer为host:port, 对方的publisher在port+1
  */
-func (w *OmqWorker) connectPub() (*zmq.Socket, *zmq.Poller) {
+func (w *OmqWorker) connectPub(peer string) (*zmq.Socket, *zmq.Poller) {
 	soc, _ := zmq.NewSocket(zmq.SUB)
 
 	//get identity
@@ -22,7 +95,9 @@ func (w *OmqWorker) connectPub() (*zmq.Socket, *zmq.Poller) {
 	soc.SetRcvhwm(50000)
 	soc.SetSubscribe("")
 
-	remotePub := fmt.Sprint("tcp://", pubAddr, ":", remotePort+1)
+	host, p, _ := net.SplitHostPort(peer)
+	port, _ := strconv.Atoi(p)
+	remotePub := fmt.Sprint("tcp://", host, ":", port+1)
 	soc.Connect(remotePub)
 	w.Debug("identity(%s) connect remote pub: %v", identity, remotePub)
 
@@ -34,12 +109,13 @@ func (w *OmqWorker) connectPub() (*zmq.Socket, *zmq.Poller) {
 
 /* }}} */
 
-/* {{{ func (w *OmqWorker) newSubscriber()
- * 订阅者, 订阅其他机房的信息
+/* {{{ func (w *OmqWorker) newSubscriber(peer string)
+ * 订阅者, 订阅其他机房的信息, 每个机房一个, 心跳以及重连各自独立
  */
-func (w *OmqWorker) newSubscriber() {
+func (w *OmqWorker) newSubscriber(peer string) {
 
-	subscriber, poller := w.connectPub()
+	status := newPeerStatus(peer)
+	subscriber, poller := w.connectPub(peer)
 
 	//  If liveness hits zero, queue is considered disconnected
 	liveness := HEARTBEAT_LIVENESS
@@ -52,7 +128,8 @@ func (w *OmqWorker) newSubscriber() {
 	for cycles := 0; true; {
 		sockets, err := poller.Poll(HEARTBEAT_INTERVAL)
 		if err != nil {
-			w.Error("sub error: %s", err)
+			w.Error("sub(%s) error: %s", peer, err)
+			status.update(func(ps *peerStatus) { ps.Connected = false })
 			break //  Interrupted
 		}
 
@@ -62,19 +139,29 @@ func (w *OmqWorker) newSubscriber() {
 			//  - 1-part HEARTBEAT -> heartbeat
 			msg, err := subscriber.RecvMessage(0)
 			if err != nil {
-				w.Error("recv error: %s", err)
+				w.Error("recv(%s) error: %s", peer, err)
+				status.update(func(ps *peerStatus) { ps.Connected = false })
 				break //  Interrupted
 			}
+			status.update(func(ps *peerStatus) {
+				ps.Connected = true
+				ps.LastSeen = time.Now().Unix()
+			})
 
 			if len(msg) > 1 {
 				cycles++
+				status.update(func(ps *peerStatus) { ps.Received++ })
 
 				//subscriber收到的信息应该是不包含信封的
 				w.Trace("recv msg: %q", msg)
 
-				// 存到本地存储(同步)
-				if err := w.localStorage(msg); err != nil {
-					w.Debug("error: %s", err)
+				// 存到本地存储(同步), 绕回来的以及重复的丢弃
+				if cmd, relay := w.replicated(msg); cmd != nil {
+					if _, err := w.localStorage(cmd); err != nil && err != ErrNil {
+						w.Debug("error: %s", err)
+					} else if relay { //本地写入成功(或本来就不存在)才转发给下游
+						publisher.SendMessage(msg)
+					}
 				}
 
 				liveness = HEARTBEAT_LIVENESS
@@ -97,14 +184,19 @@ func (w *OmqWorker) newSubscriber() {
 			//  discarding any messages we might have sent in the meantime://
 			liveness--
 			if liveness == 0 {
-				w.Error("W: heartbeat failure, can't reach pub, reconnecting in %s", interval)
+				w.Error("W: heartbeat failure, can't reach pub(%s), reconnecting in %s", peer, interval)
+				status.update(func(ps *peerStatus) {
+					ps.Connected = false
+					ps.Reconnects++
+				})
 				time.Sleep(interval)
 
 				if interval < INTERVAL_MAX { //每次重试都加大重试间隔
 					interval = 2 * interval
 				}
 				// reconnect
-				subscriber, poller = w.connectPub()
+				subscriber.Close()
+				subscriber, poller = w.connectPub(peer)
 				liveness = HEARTBEAT_LIVENESS
 			}
 		}
@@ -112,7 +204,7 @@ func (w *OmqWorker) newSubscriber() {
 		select {
 		case <-heartbeat_at:
 			if cycles > lastCycles {
-				w.Debug("subscriber worked cycles: %d", cycles)
+				w.Debug("subscriber(%s) worked cycles: %d", peer, cycles)
 				lastCycles = cycles
 			}
 		default:
@@ -0,0 +1,130 @@
+package workers
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Odinman/goutils/zredis"
+	"github.com/Odinman/ogo"
+)
+
+/*
+ * StorageOption指定Host/Port/Pwd/Db时, 连接到其他redis(或同一个redis的其他db)
+ * 目标必须在配置redis_targets中; 连接池最多缓存redis_target_pools个, 超出时关闭最久未用的
+ */
+
+const _TARGET_CLOSE_DELAY = time.Minute //淘汰的连接池延迟关闭, 让正在进行的请求完成
+
+type redisTarget struct {
+	ss   *sentinelStorage
+	used time.Time
+}
+
+var (
+	targets     = make(map[string]*redisTarget)
+	targetsLock sync.Mutex
+)
+
+/* {{{ func isDefaultRedis(opt *StorageOption) bool
+ * 没有指定其他连接参数, 使用配置的redis
+ */
+func isDefaultRedis(opt *StorageOption) bool {
+	return opt.Host == "" && opt.Port == "" && opt.Pwd == "" && (opt.Db == "" || opt.Db == redisDB)
+}
+
+/* }}} */
+
+/* {{{ func targetAllowed(addr, db string) bool
+ *
+ */
+func targetAllowed(addr, db string) bool {
+	return redisTargets[addr] || redisTargets[addr+"/"+db]
+}
+
+/* }}} */
+
+/* {{{ func openTarget(opt *StorageOption) (Storage, error)
+ * 只指定Db(以及Pwd)时连接配置的redis(包括sentinel), 否则直接连接Host:Port
+ */
+func openTarget(opt *StorageOption) (Storage, error) {
+	servers := strings.Split(redisAddr, ",")
+	sentinels := strings.Split(redisSentinel, ",")
+	host, port, _ := net.SplitHostPort(servers[0])
+	pwd := redisPwd
+	if opt.Host != "" || opt.Port != "" {
+		if opt.Host != "" {
+			host = opt.Host
+		}
+		if opt.Port != "" {
+			port = opt.Port
+		}
+		servers, sentinels = []string{net.JoinHostPort(host, port)}, nil
+		pwd = opt.Pwd
+	} else if ogo.ClusterClient() != nil {
+		return nil, fmt.Errorf("redis cluster doesn't support db")
+	} else if opt.Pwd != "" {
+		pwd = opt.Pwd
+	}
+	addr := net.JoinHostPort(host, port)
+	db := opt.Db
+	if db == "" {
+		db = redisDB
+	}
+	if !targetAllowed(addr, db) {
+		return nil, fmt.Errorf("redis target not allowed: %s/%s", addr, db)
+	}
+
+	key := strings.Join(append(servers, pwd, db), "|")
+	targetsLock.Lock()
+	if t, ok := targets[key]; ok {
+		t.used = time.Now()
+		targetsLock.Unlock()
+		return t.ss, nil
+	}
+	targetsLock.Unlock()
+
+	// 连接时不持有锁, 不影响其他目标
+	r, err := zredis.InitZRedis(servers, sentinels, pwd, db, redisMTag)
+	if err != nil {
+		return nil, err
+	}
+	targetsLock.Lock()
+	defer targetsLock.Unlock()
+	if t, ok := targets[key]; ok { //已经被其他goroutine连接
+		t.used = time.Now()
+		r.Pool.Close()
+		return t.ss, nil
+	}
+	if len(targets) >= redisTargetPool {
+		evictTarget()
+	}
+	ss := &sentinelStorage{r: r}
+	ss.eval = ss.evalScript
+	targets[key] = &redisTarget{ss: ss, used: time.Now()}
+	return ss, nil
+}
+
+/* }}} */
+
+/* {{{ func evictTarget()
+ * 关闭最久未用的连接池, 调用者持有targetsLock
+ */
+func evictTarget() {
+	var oldest string
+	for k, t := range targets {
+		if oldest == "" || t.used.Before(targets[oldest].used) {
+			oldest = k
+		}
+	}
+	if t, ok := targets[oldest]; ok {
+		delete(targets, oldest)
+		time.AfterFunc(_TARGET_CLOSE_DELAY, func() {
+			t.ss.r.Pool.Close()
+		})
+	}
+}
+
+/* }}} */
@@ -0,0 +1,601 @@
+package workers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	ogoutils "github.com/Odinman/ogo/utils"
+)
+
+const (
+	TASK_QUEUED    = "QUEUED"    //在队列中
+	TASK_RUNNING   = "RUNNING"   //已被领取
+	TASK_WAITING   = "WAITING"   //等待重试
+	TASK_DELIVERED = "DELIVERED" //已被领取(PUSH任务, 不跟踪完成情况)
+	TASK_COMPLETED = "COMPLETED" //完成
+	TASK_FAILED    = "FAILED"    //失败
+	TASK_TIMEOUT   = "TIMEOUT"   //超时
+
+	_RETRY_KEY_PREFIX = "omq:retry:" //重试定时集合, 后接节点标识
+	_TASK_KEY_PREFIX  = "omq:task:"  //任务记录
+	_TASK_VALUE_KEY   = ":value"     //任务记录key后接, 等待重试任务的内容(重启之后重新入队用)
+
+	_TASK_MAX_AGE = 24 * time.Hour //不检查超时的任务领取后最多在内存中保留的时间(task_retention为0时)
+
+	_REASON_TIMEOUT = "timeout"
+)
+
+type RetryPolicy struct {
+	MaxAttempts int //最多尝试次数
+	Backoff     int //首次重试等待秒数, 之后每次翻倍
+	MaxBackoff  int //等待上限(秒)
+	Timeout     int //领取后未完成的超时秒数, 0为不检查
+}
+
+type Attempt struct {
+	At     time.Time
+	Reason string
+}
+
+type Task struct {
+	Id       string
+	Queue    string
+	Value    []string `json:"-"`
+	Policy   RetryPolicy
+	State    string
+	Attempts []Attempt //每次失败的记录
+	Result   string
+	Parent   string `json:",omitempty"` //SCATTER的父任务
+	Created  time.Time
+	Updated  time.Time
+	deadline time.Time //领取超时时间
+	plain    bool      //普通任务, 领取时去掉任务id
+	flow     string    //所属工作流
+	step     string    //工作流中的步骤
+}
+
+type taskRegistry struct {
+	lock     sync.Mutex
+	tasks    map[string]*Task
+	policies map[string]RetryPolicy //队列重试策略
+}
+
+var tasks *taskRegistry
+
+/* {{{ func newTaskRegistry() *taskRegistry
+ *
+ */
+func newTaskRegistry() *taskRegistry {
+	return &taskRegistry{
+		tasks:    make(map[string]*Task),
+		policies: make(map[string]RetryPolicy),
+	}
+}
+
+/* }}} */
+
+/* {{{ func defaultPolicy() RetryPolicy
+ * 配置文件中的默认策略
+ */
+func defaultPolicy() RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: retryMax,
+		Backoff:     retryBackoff,
+		MaxBackoff:  retryBackoffMax,
+		Timeout:     retryTimeout,
+	}
+}
+
+/* }}} */
+
+/* {{{ func (p RetryPolicy) merge(d RetryPolicy) RetryPolicy
+ * 未设置的字段使用d中的值
+ */
+func (p RetryPolicy) merge(d RetryPolicy) RetryPolicy {
+	if p.MaxAttempts <= 0 {
+		p.MaxAttempts = d.MaxAttempts
+	}
+	if p.Backoff <= 0 {
+		p.Backoff = d.Backoff
+	}
+	if p.MaxBackoff <= 0 {
+		p.MaxBackoff = d.MaxBackoff
+	}
+	if p.Timeout <= 0 {
+		p.Timeout = d.Timeout
+	}
+	return p
+}
+
+/* }}} */
+
+/* {{{ func (p RetryPolicy) delay(attempts int) time.Duration
+ * 第attempts次失败后的等待时间, 指数退避
+ */
+func (p RetryPolicy) delay(attempts int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempts && d < p.MaxBackoff; i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	return time.Duration(d) * time.Second
+}
+
+/* }}} */
+
+/* {{{ func (t *Task) message() []string
+ * 放入队列的内容, 任务id放在最前
+ */
+func (t *Task) message() []string {
+	return append([]string{t.Id}, t.Value...)
+}
+
+/* }}} */
+
+/* {{{ func (r *taskRegistry) add(t *Task)
+ *
+ */
+func (r *taskRegistry) add(t *Task) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.tasks[t.Id] = t
+}
+
+/* }}} */
+
+/* {{{ func (r *taskRegistry) remove(id string) (t *Task)
+ *
+ */
+func (r *taskRegistry) remove(id string) (t *Task) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if t = r.tasks[id]; t != nil {
+		delete(r.tasks, id)
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (r *taskRegistry) deliver(msg []string) ([]string, *Task)
+ * 任务被领取, 返回交给领取者的内容
+ */
+func (r *taskRegistry) deliver(msg []string) ([]string, *Task) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if len(msg) == 0 {
+		return msg, nil
+	}
+	t, ok := r.tasks[msg[0]]
+	if !ok {
+		return msg, nil
+	}
+	t.Updated = time.Now()
+	if t.plain { //普通任务领取即结束
+		t.State = TASK_DELIVERED
+		delete(r.tasks, t.Id)
+		return msg[1:], t
+	}
+	if t.State == TASK_QUEUED {
+		t.State = TASK_RUNNING
+		if t.Policy.Timeout > 0 {
+			t.deadline = t.Updated.Add(time.Duration(t.Policy.Timeout) * time.Second)
+		}
+	}
+	return msg, t
+}
+
+/* }}} */
+
+/* {{{ func (r *taskRegistry) expired(now time.Time) (ids []string)
+ * 领取之后超时未完成的任务
+ */
+func (r *taskRegistry) expired(now time.Time) (ids []string) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for id, t := range r.tasks {
+		if t.State == TASK_RUNNING && !t.deadline.IsZero() && now.After(t.deadline) {
+			ids = append(ids, id)
+		}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (r *taskRegistry) prune(now time.Time) (ids []string)
+ * 不检查超时(retry_timeout为0)的任务, 领取后一直没有结果的从内存中移除(记录保留)
+ */
+func (r *taskRegistry) prune(now time.Time) (ids []string) {
+	maxAge := _TASK_MAX_AGE
+	if taskRetention > 0 {
+		maxAge = time.Duration(taskRetention) * time.Second
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	for id, t := range r.tasks {
+		if t.State == TASK_RUNNING && t.deadline.IsZero() && now.Sub(t.Updated) > maxAge {
+			delete(r.tasks, id)
+			ids = append(ids, id)
+		}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (r *taskRegistry) policy(queue string) RetryPolicy
+ * 队列策略, 没有设置则为默认策略
+ */
+func (r *taskRegistry) policy(queue string) RetryPolicy {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	return r.policies[queue].merge(defaultPolicy())
+}
+
+/* }}} */
+
+/* {{{ func (r *taskRegistry) setPolicy(queue string, p RetryPolicy)
+ *
+ */
+func (r *taskRegistry) setPolicy(queue string, p RetryPolicy) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	r.policies[queue] = p
+}
+
+/* }}} */
+
+/* {{{ func newTask(id, queue string, option string, value []string) (*Task, error)
+ * option为json格式的重试策略, 为空则使用队列策略
+ */
+func newTask(id, queue string, option string, value []string) (*Task, error) {
+	var p RetryPolicy
+	if option != "" {
+		if err := json.Unmarshal([]byte(option), &p); err != nil {
+			return nil, fmt.Errorf("unmarshal policy failed: %s", err)
+		}
+	}
+	return &Task{
+		Id:     id,
+		Queue:  queue,
+		Value:  value,
+		Policy: p.merge(tasks.policy(queue)),
+	}, nil
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) pushTask(t *Task) error
+ * 任务入队
+ */
+func (w *OmqWorker) pushTask(t *Task) error {
+	t.State = TASK_QUEUED
+	t.Created = time.Now()
+	t.Updated = t.Created
+	tasks.add(t)
+	if err := mqpool.Push(t.Queue, t.message()); err != nil {
+		tasks.remove(t.Id)
+		return err
+	}
+	w.saveTask(t)
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) pushPlain(queue string, value []string) (string, error)
+ * 普通任务入队, 配置track_push时才记录状态(返回任务id), 否则与原来一样直接入队
+ */
+func (w *OmqWorker) pushPlain(queue string, value []string) (string, error) {
+	if !trackPush {
+		return "", mqpool.Push(queue, value)
+	}
+	t := &Task{Id: ogoutils.NewShortUUID(), Queue: queue, Value: value, plain: true}
+	if err := w.pushTask(t); err != nil {
+		return "", err
+	}
+	return t.Id, nil
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) endTask(id, state, result string) *Task
+ * 任务结束, 从内存中移除, 保留记录
+ */
+func (w *OmqWorker) endTask(id, state, result string) *Task {
+	t := tasks.remove(id)
+	if t == nil {
+		return nil
+	}
+	tasks.lock.Lock()
+	waiting := t.State == TASK_WAITING
+	t.State = state
+	t.Result = result
+	t.Updated = time.Now()
+	tasks.lock.Unlock()
+	w.saveTask(t)
+	if waiting {
+		w.dropTaskValue(id)
+	}
+	if t.flow != "" {
+		if state == TASK_COMPLETED {
+			w.advanceFlow(t.flow, t.step, result)
+		} else {
+			w.advanceFlow(t.flow, t.step, "0")
+		}
+	}
+	return t
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) finishTask(id, result string) bool
+ * 任务结束(完成或者失败), 通知等待的BTASK以及所属工作流
+ */
+func (w *OmqWorker) finishTask(id, result string) bool {
+	state := TASK_COMPLETED
+	if result == "0" {
+		state = TASK_FAILED
+	}
+	found := w.endTask(id, state, result) != nil
+	return w.notifyBlock(id, result) || found
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) notifyBlock(id, result string) bool
+ * 通知等待结果的BTASK
+ */
+func (w *OmqWorker) notifyBlock(id, result string) bool {
+	blockLock.Lock()
+	defer blockLock.Unlock()
+	if c, ok := blockTasks[id]; ok {
+		select {
+		case c <- result:
+		default: //已经有结果
+		}
+		return true
+	}
+	return false
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) retryTask(id, reason string) error
+ * 任务失败, 如果还有机会, 退避之后重新入队
+ */
+func (w *OmqWorker) retryTask(id, reason string) error {
+	tasks.lock.Lock()
+	t, ok := tasks.tasks[id]
+	if !ok || t.State != TASK_RUNNING {
+		tasks.lock.Unlock()
+		return fmt.Errorf("task not running: %s", id)
+	}
+	t.Updated = time.Now()
+	t.Attempts = append(t.Attempts, Attempt{At: t.Updated, Reason: reason})
+	attempts := len(t.Attempts)
+	if attempts >= t.Policy.MaxAttempts {
+		tasks.lock.Unlock()
+		w.Info("task %s failed after %d attempts: %s", id, attempts, reason)
+		state := TASK_FAILED
+		if reason == _REASON_TIMEOUT {
+			state = TASK_TIMEOUT
+		}
+		w.endTask(id, state, "")
+		w.notifyBlock(id, "0")
+		return nil
+	}
+	t.State = TASK_WAITING
+	tasks.lock.Unlock()
+	w.saveTask(t)
+	w.saveTaskValue(t)
+
+	delay := t.Policy.delay(attempts)
+	w.Debug("task %s attempt %d failed: %s, retry in %s", id, attempts, reason, delay)
+	if !storageReady() { //没有本地存储, 只能用本地定时器
+		time.AfterFunc(delay, func() { w.requeueTask(id) })
+		return nil
+	}
+	ls := &LocalStorage{
+		key:   _RETRY_KEY_PREFIX + nodeId,
+		value: id,
+		ts:    int(time.Now().Add(delay).Unix()),
+	}
+	if err := ls.Schedule(); err != nil {
+		w.Info("schedule retry failed: %s", err)
+		time.AfterFunc(delay, func() { w.requeueTask(id) })
+	}
+	return nil
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) requeueTask(id string)
+ * 重新入队(只处理等待重试的任务)
+ */
+func (w *OmqWorker) requeueTask(id string) {
+	tasks.lock.Lock()
+	t, ok := tasks.tasks[id]
+	tasks.lock.Unlock()
+	if !ok { //重启之前等待重试的任务, 从记录中恢复
+		if t = w.loadTask(id); t == nil {
+			w.Info("task %s not found, drop retry", id)
+			return
+		}
+		tasks.lock.Lock()
+		if cur, ok := tasks.tasks[id]; ok {
+			t = cur
+		} else {
+			tasks.tasks[id] = t
+		}
+		tasks.lock.Unlock()
+	}
+	tasks.lock.Lock()
+	if t.State != TASK_WAITING {
+		tasks.lock.Unlock()
+		return
+	}
+	t.State = TASK_QUEUED
+	t.Updated = time.Now()
+	t.deadline = time.Time{}
+	tasks.lock.Unlock()
+	if err := mqpool.Push(t.Queue, t.message()); err != nil {
+		w.Info("requeue task %s failed: %s", id, err)
+		w.finishTask(id, "0")
+	} else {
+		w.Debug("requeue task %s to %s", id, t.Queue)
+		w.saveTask(t)
+		w.dropTaskValue(id)
+	}
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) newRetrier()
+ * 定时把到期的重试任务放回队列, 并检查领取超时的任务
+ */
+func (w *OmqWorker) newRetrier() {
+	ls := &LocalStorage{key: _RETRY_KEY_PREFIX + nodeId, batch: 100}
+	for now := range time.Tick(time.Second) {
+		for storageReady() {
+			ids, err := ls.Timing()
+			if err != nil {
+				if err != ErrNil {
+					w.Debug("timing retry failed: %s", err)
+				}
+				break
+			}
+			for _, id := range ids {
+				w.requeueTask(id)
+			}
+			if len(ids) < ls.batch {
+				break
+			}
+		}
+		for _, id := range tasks.expired(now) {
+			w.retryTask(id, _REASON_TIMEOUT)
+		}
+		for _, id := range tasks.prune(now) {
+			w.Debug("task %s running too long without timeout, forget it", id)
+		}
+	}
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) saveTask(t *Task)
+ * 保存任务记录, 保留task_retention秒
+ */
+func (w *OmqWorker) saveTask(t *Task) {
+	if taskRetention <= 0 || !storageReady() {
+		return
+	}
+	tasks.lock.Lock()
+	tj, err := json.Marshal(t)
+	tasks.lock.Unlock()
+	if err != nil {
+		w.Info("marshal task %s failed: %s", t.Id, err)
+		return
+	}
+	ls := &LocalStorage{
+		key:    _TASK_KEY_PREFIX + t.Id,
+		value:  string(tj),
+		expire: taskRetention,
+	}
+	if err := ls.Set(); err != nil {
+		w.Debug("save task %s failed: %s", t.Id, err)
+	}
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) saveTaskValue(t *Task)
+ * 保存等待重试任务的内容, 与记录一起用于重启之后重新入队
+ */
+func (w *OmqWorker) saveTaskValue(t *Task) {
+	if taskRetention <= 0 || !storageReady() {
+		return
+	}
+	vj, _ := json.Marshal(t.Value)
+	ls := &LocalStorage{
+		key:    _TASK_KEY_PREFIX + t.Id + _TASK_VALUE_KEY,
+		value:  string(vj),
+		expire: taskRetention,
+	}
+	if err := ls.Set(); err != nil {
+		w.Debug("save task %s value failed: %s", t.Id, err)
+	}
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) dropTaskValue(id string)
+ *
+ */
+func (w *OmqWorker) dropTaskValue(id string) {
+	if taskRetention <= 0 || !storageReady() {
+		return
+	}
+	ls := &LocalStorage{key: _TASK_KEY_PREFIX + id + _TASK_VALUE_KEY}
+	ls.Del()
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) loadTask(id string) *Task
+ * 从记录恢复等待重试的任务, 记录或内容不存在返回nil
+ */
+func (w *OmqWorker) loadTask(id string) *Task {
+	if !storageReady() {
+		return nil
+	}
+	ls := &LocalStorage{key: _TASK_KEY_PREFIX + id}
+	r, err := ls.Get()
+	if err != nil {
+		return nil
+	}
+	t := new(Task)
+	if err := json.Unmarshal([]byte(r[0]), t); err != nil || t.State != TASK_WAITING {
+		return nil
+	}
+	ls.key += _TASK_VALUE_KEY
+	if r, err = ls.Get(); err != nil {
+		return nil
+	}
+	if err := json.Unmarshal([]byte(r[0]), &t.Value); err != nil {
+		return nil
+	}
+	return t
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) taskStatus(id string) (string, error)
+ * 任务状态(json), 先查内存, 再查记录
+ */
+func (w *OmqWorker) taskStatus(id string) (string, error) {
+	tasks.lock.Lock()
+	t, ok := tasks.tasks[id]
+	if ok {
+		tj, err := json.Marshal(t)
+		tasks.lock.Unlock()
+		return string(tj), err
+	}
+	tasks.lock.Unlock()
+	if !storageReady() {
+		return "", ErrNil
+	}
+	ls := &LocalStorage{key: _TASK_KEY_PREFIX + id}
+	if r, err := ls.Get(); err != nil {
+		return "", err
+	} else {
+		return r[0], nil
+	}
+}
+
+/* }}} */
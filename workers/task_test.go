@@ -0,0 +1,50 @@
+package workers
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestLoadWaitingTask(t *testing.T) {
+	w := newTestWorker(t)
+	task := &Task{Id: "t1", Queue: "q", Value: []string{"a", "b"}, State: TASK_WAITING, Policy: RetryPolicy{MaxAttempts: 3}}
+	w.saveTask(task)
+	w.saveTaskValue(task)
+
+	// 重启之后内存中没有, 从记录恢复
+	loaded := w.loadTask("t1")
+	if loaded == nil {
+		t.Fatal("waiting task not loaded")
+	}
+	if loaded.Queue != "q" || fmt.Sprint(loaded.Value) != "[a b]" || loaded.Policy.MaxAttempts != 3 {
+		t.Fatalf("loaded %+v", loaded)
+	}
+
+	// 已经不在等待重试的不恢复
+	task.State = TASK_COMPLETED
+	w.saveTask(task)
+	if w.loadTask("t1") != nil {
+		t.Fatal("completed task loaded")
+	}
+	if w.loadTask("missing") != nil {
+		t.Fatal("missing task loaded")
+	}
+}
+
+func TestPruneTasks(t *testing.T) {
+	newTestWorker(t)
+	now := time.Now()
+	old := now.Add(-time.Duration(taskRetention+1) * time.Second)
+	tasks.add(&Task{Id: "stale", State: TASK_RUNNING, Updated: old})
+	tasks.add(&Task{Id: "fresh", State: TASK_RUNNING, Updated: now})
+	tasks.add(&Task{Id: "timed", State: TASK_RUNNING, Updated: old, deadline: now.Add(time.Minute)})
+	tasks.add(&Task{Id: "waiting", State: TASK_WAITING, Updated: old})
+
+	if ids := tasks.prune(now); fmt.Sprint(ids) != "[stale]" {
+		t.Fatalf("pruned %q", ids)
+	}
+	if len(tasks.tasks) != 3 {
+		t.Fatalf("%d tasks left", len(tasks.tasks))
+	}
+}
@@ -0,0 +1,53 @@
+package workers
+
+import (
+	"fmt"
+
+	"github.com/Odinman/omq/utils"
+	zmq "github.com/pebbe/zmq4"
+)
+
+/*
+ * 变更通知: 通过omq(包括其他机房同步过来)的修改成功后, 在watch端口(默认basePort+2)发布
+ * 每条通知: key, act, value...
+ * 客户端用SUB连接, 订阅key(或者key前缀)即可, zmq按前缀过滤
+ */
+
+var watcher *utils.Socket
+
+/* {{{ func (w *OmqWorker) bindWatcher()
+ *
+ */
+func (w *OmqWorker) bindWatcher() {
+	watcher = utils.NewSocket(zmq.PUB, 50000)
+	watcher.Bind(fmt.Sprint("tcp://*:", watchPort))
+	w.Debug("watcher bind port: %v", watchPort)
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) notifyWatch(act string, ls *LocalStorage)
+ * 发布变更, MSET拆成每个key一条
+ */
+func (w *OmqWorker) notifyWatch(act string, ls *LocalStorage) {
+	if watcher == nil {
+		return
+	}
+	switch act {
+	case COMMAND_MSET:
+		kvs := append([]string{ls.key}, ls.args...)
+		for i := 0; i+1 < len(kvs); i += 2 {
+			watcher.SendMessage(kvs[i], act, kvs[i+1])
+		}
+	default:
+		args := ls.args
+		if len(args) > 0 && isEncoded(args[0]) { //通知原值(写入的命令已经编码)
+			if v, err := decodeValue(args[0]); err == nil {
+				args = append([]string{v}, args[1:]...)
+			}
+		}
+		watcher.SendMessage(ls.key, act, args)
+	}
+}
+
+/* }}} */
@@ -0,0 +1,294 @@
+package workers
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	ogoutils "github.com/Odinman/ogo/utils"
+)
+
+const (
+	STEP_PENDING   = "PENDING"   //等待上游完成
+	STEP_QUEUED    = "QUEUED"    //已入队
+	STEP_COMPLETED = "COMPLETED" //完成
+	STEP_FAILED    = "FAILED"    //失败
+	STEP_SKIPPED   = "SKIPPED"   //上游失败, 不再执行
+
+	FLOW_RUNNING   = "RUNNING"
+	FLOW_COMPLETED = "COMPLETED"
+	FLOW_FAILED    = "FAILED"
+)
+
+type Step struct {
+	Name   string
+	Queue  string
+	Value  []string
+	After  []string     //依赖的步骤
+	Policy *RetryPolicy `json:",omitempty"`
+	TaskId string
+	State  string
+	Result string
+}
+
+type Workflow struct {
+	Id       string
+	State    string
+	Steps    []*Step
+	Created  time.Time
+	Finished time.Time
+	index    map[string]*Step
+}
+
+type flowRegistry struct {
+	lock  sync.Mutex
+	flows map[string]*Workflow
+}
+
+var flows *flowRegistry
+
+/* {{{ func parseWorkflow(def string) (f *Workflow, err error)
+ * 解析工作流定义(json数组), 检查依赖关系
+ */
+func parseWorkflow(def string) (f *Workflow, err error) {
+	steps := make([]*Step, 0)
+	if err = json.Unmarshal([]byte(def), &steps); err != nil {
+		return nil, fmt.Errorf("unmarshal workflow failed: %s", err)
+	}
+	if len(steps) == 0 {
+		return nil, fmt.Errorf("empty workflow")
+	}
+	f = &Workflow{
+		Id:      ogoutils.NewShortUUID(),
+		State:   FLOW_RUNNING,
+		Steps:   steps,
+		Created: time.Now(),
+		index:   make(map[string]*Step),
+	}
+	for _, s := range steps {
+		if s.Name == "" || s.Queue == "" {
+			return nil, fmt.Errorf("step need name and queue")
+		}
+		if _, ok := f.index[s.Name]; ok {
+			return nil, fmt.Errorf("duplicate step: %s", s.Name)
+		}
+		s.State = STEP_PENDING
+		s.TaskId = ""
+		s.Result = ""
+		f.index[s.Name] = s
+	}
+	// 检查依赖是否存在, 是否有环
+	degree := make(map[string]int)
+	for _, s := range steps {
+		after := make([]string, 0, len(s.After))
+		seen := make(map[string]bool)
+		for _, p := range s.After {
+			if _, ok := f.index[p]; !ok {
+				return nil, fmt.Errorf("step %s depends on unknown step: %s", s.Name, p)
+			} else if !seen[p] { //重复的依赖只算一次
+				seen[p] = true
+				after = append(after, p)
+			}
+		}
+		s.After = after
+		degree[s.Name] = len(s.After)
+	}
+	for sorted := 0; sorted < len(steps); {
+		found := false
+		for _, s := range steps {
+			if degree[s.Name] == 0 {
+				degree[s.Name] = -1
+				sorted++
+				found = true
+				for _, c := range f.children(s.Name) {
+					degree[c.Name]--
+				}
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("workflow has cycle")
+		}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (f *Workflow) children(name string) (cs []*Step)
+ * 直接依赖name的步骤
+ */
+func (f *Workflow) children(name string) (cs []*Step) {
+	for _, s := range f.Steps {
+		for _, p := range s.After {
+			if p == name {
+				cs = append(cs, s)
+				break
+			}
+		}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (f *Workflow) ready() (rs []*Step)
+ * 可以入队的步骤(所有上游都已完成), 同时标记为已入队
+ */
+func (f *Workflow) ready() (rs []*Step) {
+	for _, s := range f.Steps {
+		if s.State != STEP_PENDING {
+			continue
+		}
+		ok := true
+		for _, p := range s.After {
+			if f.index[p].State != STEP_COMPLETED {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			s.State = STEP_QUEUED
+			s.TaskId = ogoutils.NewShortUUID()
+			rs = append(rs, s)
+		}
+	}
+	return
+}
+
+/* }}} */
+
+/* {{{ func (f *Workflow) skip(name string)
+ * 上游失败, 所有下游都不再执行
+ */
+func (f *Workflow) skip(name string) {
+	for _, c := range f.children(name) {
+		if c.State == STEP_PENDING {
+			c.State = STEP_SKIPPED
+			f.skip(c.Name)
+		}
+	}
+}
+
+/* }}} */
+
+/* {{{ func (f *Workflow) update() bool
+ * 所有步骤都结束则工作流结束, 返回是否结束
+ */
+func (f *Workflow) update() bool {
+	state := FLOW_COMPLETED
+	for _, s := range f.Steps {
+		switch s.State {
+		case STEP_PENDING, STEP_QUEUED:
+			return false
+		case STEP_FAILED, STEP_SKIPPED:
+			state = FLOW_FAILED
+		}
+	}
+	f.State = state
+	f.Finished = time.Now()
+	return true
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) newWorkflow(def string) (*Workflow, error)
+ * 提交工作流, 没有依赖的步骤立即入队
+ */
+func (w *OmqWorker) newWorkflow(def string) (*Workflow, error) {
+	f, err := parseWorkflow(def)
+	if err != nil {
+		return nil, err
+	}
+	flows.lock.Lock()
+	flows.flows[f.Id] = f
+	ready := f.ready()
+	flows.lock.Unlock()
+
+	w.Debug("new workflow %s, %d steps", f.Id, len(f.Steps))
+	w.pushSteps(f, ready)
+	return f, nil
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) pushSteps(f *Workflow, steps []*Step)
+ *
+ */
+func (w *OmqWorker) pushSteps(f *Workflow, steps []*Step) {
+	for _, s := range steps {
+		t := &Task{
+			Id:    s.TaskId,
+			Queue: s.Queue,
+			Value: s.Value,
+			flow:  f.Id,
+			step:  s.Name,
+		}
+		if s.Policy != nil {
+			t.Policy = s.Policy.merge(tasks.policy(s.Queue))
+		} else {
+			t.Policy = tasks.policy(s.Queue)
+		}
+		if err := w.pushTask(t); err != nil {
+			w.Info("push workflow %s step %s failed: %s", f.Id, s.Name, err)
+			w.advanceFlow(f.Id, s.Name, "0")
+		}
+	}
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) advanceFlow(id, name, result string)
+ * 步骤结束, result为"0"表示失败
+ */
+func (w *OmqWorker) advanceFlow(id, name, result string) {
+	flows.lock.Lock()
+	f, ok := flows.flows[id]
+	if !ok {
+		flows.lock.Unlock()
+		return
+	}
+	s, ok := f.index[name]
+	if !ok || s.State != STEP_QUEUED {
+		flows.lock.Unlock()
+		return
+	}
+	var ready []*Step
+	if result == "0" {
+		s.State = STEP_FAILED
+		f.skip(name)
+	} else {
+		s.State = STEP_COMPLETED
+		s.Result = result
+		ready = f.ready()
+	}
+	if f.update() {
+		w.Debug("workflow %s finished: %s", id, f.State)
+		time.AfterFunc(time.Duration(flowRetention)*time.Second, func() {
+			flows.lock.Lock()
+			delete(flows.flows, id)
+			flows.lock.Unlock()
+		})
+	}
+	flows.lock.Unlock()
+
+	w.pushSteps(f, ready)
+}
+
+/* }}} */
+
+/* {{{ func (w *OmqWorker) flowStatus(id string) (string, error)
+ * 工作流状态(json)
+ */
+func (w *OmqWorker) flowStatus(id string) (string, error) {
+	flows.lock.Lock()
+	defer flows.lock.Unlock()
+	f, ok := flows.flows[id]
+	if !ok {
+		return "", ErrNil
+	}
+	fj, err := json.Marshal(f)
+	return string(fj), err
+}
+
+/* }}} */
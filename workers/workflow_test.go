@@ -0,0 +1,69 @@
+package workers
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func readyNames(f *Workflow) string {
+	names := []string{}
+	for _, s := range f.ready() {
+		names = append(names, s.Name)
+	}
+	sort.Strings(names)
+	return strings.Join(names, ",")
+}
+
+func TestWorkflowParse(t *testing.T) {
+	for def, ok := range map[string]bool{
+		`[{"Name":"a","Queue":"q"},{"Name":"b","Queue":"q","After":["a","a"]}]`:           true, //重复依赖不是环
+		`[{"Name":"a","Queue":"q","After":["b"]},{"Name":"b","Queue":"q","After":["a"]}]`: false,
+		`[{"Name":"a","Queue":"q","After":["a"]}]`:                                        false,
+		`[{"Name":"a","Queue":"q","After":["x"]}]`:                                        false,
+		`[{"Name":"a","Queue":"q"},{"Name":"a","Queue":"q"}]`:                             false,
+		`[{"Name":"a"}]`: false,
+		`[]`:             false,
+	} {
+		if _, err := parseWorkflow(def); (err == nil) != ok {
+			t.Errorf("%s: %v", def, err)
+		}
+	}
+}
+
+func TestWorkflowReady(t *testing.T) {
+	f, err := parseWorkflow(`[
+		{"Name":"a","Queue":"q"},
+		{"Name":"b","Queue":"q","After":["a"]},
+		{"Name":"c","Queue":"q","After":["a"]},
+		{"Name":"d","Queue":"q","After":["b","c","b"]},
+		{"Name":"e","Queue":"q","After":["d"]}
+	]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := readyNames(f); r != "a" {
+		t.Fatalf("ready %s, want a", r)
+	}
+	if r := readyNames(f); r != "" { //已入队的不再返回
+		t.Fatalf("ready twice %s", r)
+	}
+	f.index["a"].State = STEP_COMPLETED
+	if r := readyNames(f); r != "b,c" {
+		t.Fatalf("ready %s, want b,c", r)
+	}
+	f.index["b"].State = STEP_COMPLETED
+	if r := readyNames(f); r != "" {
+		t.Fatalf("ready %s before c completed", r)
+	}
+
+	// 上游失败, 下游都跳过
+	f.index["c"].State = STEP_FAILED
+	f.skip("c")
+	if f.index["d"].State != STEP_SKIPPED || f.index["e"].State != STEP_SKIPPED {
+		t.Fatalf("d %s, e %s", f.index["d"].State, f.index["e"].State)
+	}
+	if !f.update() || f.State != FLOW_FAILED {
+		t.Fatalf("flow %s", f.State)
+	}
+}